@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
@@ -22,6 +23,10 @@ var INFO *zap.SugaredLogger
 var DEBUG *zap.SugaredLogger
 var ERROR *zap.SugaredLogger
 
+// opened log file writers, closed at shutdown
+var writers []io.Closer
+var writersLock sync.Mutex
+
 var logEncoder = zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
 	MessageKey:  "msg",
 	LevelKey:    "level",
@@ -116,9 +121,30 @@ func getWriter(filename string) io.Writer {
 	if err != nil {
 		panic(err)
 	}
+	writersLock.Lock()
+	writers = append(writers, hook)
+	writersLock.Unlock()
 	return hook
 }
 
+// new rotated writer of log file, e.g. access log
+func NewWriter(filename string) io.Writer {
+	return getWriter(filename)
+}
+
+// flush loggers and close log files
+func Close() {
+	for _, logger := range []*zap.SugaredLogger{Log, INFO, DEBUG, ERROR} {
+		logger.Sync()
+	}
+	writersLock.Lock()
+	defer writersLock.Unlock()
+	for _, w := range writers {
+		w.Close()
+	}
+	writers = nil
+}
+
 // log print method
 func Debug(args ...interface{}) {
 	Log.Debug(args...)
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"synapsor/pkg/plugins/httpserver/service"
+	"synapsor/pkg/plugins/httpserver/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+//controller struct
+type DescriptorController struct {
+	apiVersion string
+	Service    *service.DescriptorService
+}
+
+//get controller
+func (dc *DescriptorController) getCtl() *DescriptorController {
+	var svc *service.DescriptorService
+	return &DescriptorController{"v1", svc}
+}
+
+//get descriptors of proxies
+func (dc *DescriptorController) GetDescriptors(c *gin.Context) {
+	descriptors, err := dc.getCtl().Service.GetDescriptors()
+	// error
+	if err != nil {
+		util.SendError(c, err.Error())
+		return
+	}
+	// send message
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+		Data: map[string]interface{}{
+			"descriptors": descriptors,
+		},
+	})
+}
+
+//refresh descriptors of proxy
+func (dc *DescriptorController) RefreshDescriptors(c *gin.Context) {
+	err := dc.getCtl().Service.RefreshDescriptors(c.Param("proxy"))
+	// error
+	if err != nil {
+		util.SendError(c, err.Error())
+		return
+	}
+	// send message
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+	})
+}
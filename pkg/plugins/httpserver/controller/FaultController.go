@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"net/http"
+	"synapsor/pkg/plugins/httpserver/service"
+	"synapsor/pkg/plugins/httpserver/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+//controller struct
+type FaultController struct {
+	apiVersion string
+	Service    *service.FaultService
+}
+
+//fault rule switch request
+type faultSwitchRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+//get controller
+func (fc *FaultController) getCtl() *FaultController {
+	var svc *service.FaultService
+	return &FaultController{"v1", svc}
+}
+
+//get fault rules
+func (fc *FaultController) GetFaultRules(c *gin.Context) {
+	rules, err := fc.getCtl().Service.GetFaultRules()
+	// error
+	if err != nil {
+		util.SendError(c, err.Error())
+		return
+	}
+	// send message
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+		Data: map[string]interface{}{
+			"faults": rules,
+		},
+	})
+}
+
+//enable or disable fault rule
+func (fc *FaultController) SwitchFaultRule(c *gin.Context) {
+	var req faultSwitchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    -1,
+			"message": err.Error(),
+		})
+		return
+	}
+	err := fc.getCtl().Service.SetFaultRuleEnabled(c.Param("proxy"), c.Param("rule"), req.Enabled)
+	// error
+	if err != nil {
+		util.SendError(c, err.Error())
+		return
+	}
+	// send message
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+	})
+}
@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"net/http"
+	"synapsor/pkg/plugins/httpserver/service"
+	"synapsor/pkg/plugins/httpserver/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+//controller struct
+type HealthController struct {
+	apiVersion string
+	Service    *service.HealthService
+}
+
+//get controller
+func (hc *HealthController) getCtl() *HealthController {
+	var svc *service.HealthService
+	return &HealthController{"v1", svc}
+}
+
+//readiness probe, not ready during shutdown
+func (hc *HealthController) GetReady(c *gin.Context) {
+	if !hc.getCtl().Service.Ready() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"code":    -1,
+			"message": "not ready",
+		})
+		return
+	}
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+	})
+}
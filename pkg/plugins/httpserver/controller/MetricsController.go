@@ -57,3 +57,31 @@ func (uc *MetricsController) GetPoolMetricsData(c *gin.Context) {
 		},
 	})
 }
+
+//get mirror metrics data
+func (uc *MetricsController) GetMirrorMetricsData(c *gin.Context) {
+
+	mDatas, err := uc.getCtl().Service.GetMirrorData()
+
+	// error
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    -1,
+			"message": err,
+		})
+		return
+	}
+	// send message
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+		Data: map[string]interface{}{
+			"mirror": mDatas,
+		},
+	})
+}
+
+//get prometheus metrics
+func (uc *MetricsController) GetPrometheusMetrics(c *gin.Context) {
+	uc.getCtl().Service.GetPrometheusHandler().ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"synapsor/pkg/plugins/httpserver/service"
+	"synapsor/pkg/plugins/httpserver/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+//controller struct
+type TranscodeController struct {
+	apiVersion string
+	Service    *service.TranscodeService
+}
+
+//get controller
+func (tc *TranscodeController) getCtl() *TranscodeController {
+	var svc *service.TranscodeService
+	return &TranscodeController{"v1", svc}
+}
+
+//transcode middleware, http/json request matched google.api.http rule is sent to grpc backend
+func (tc *TranscodeController) Transcode(c *gin.Context) {
+	if tc.getCtl().Service.Transcode(c.Writer, c.Request) {
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+//get transcode routes
+func (tc *TranscodeController) GetTranscodeRoutes(c *gin.Context) {
+	routes, err := tc.getCtl().Service.GetTranscodeRoutes()
+	// error
+	if err != nil {
+		util.SendError(c, err.Error())
+		return
+	}
+	// send message
+	util.SendMessage(c, util.Message{
+		Code:    0,
+		Message: "OK",
+		Data: map[string]interface{}{
+			"routes": routes,
+		},
+	})
+}
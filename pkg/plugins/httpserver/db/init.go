@@ -110,6 +110,11 @@ func dbInit() {
 	//db config init
 	dbMap := dbConfigInit()
 	dsn, dbName, dbDriver := dbMap["dsn"].(string), dbMap["dbName"].(string), dbMap["dbDriver"].(string)
+	// db is optional, e.g. only redis is used by response cache
+	if dbDriver == "" {
+		log.Println("db: no db driver, skip db connect")
+		return
+	}
 	// db dsn
 	var err error
 	// set db log level
@@ -176,6 +181,10 @@ func dbInit() {
 	if err != nil {
 		panic(err.Error())
 	}
+	// unsupported driver or db check failed
+	if Conn == nil {
+		return
+	}
 
 	log.Println("db: mysql connect successed !")
 
@@ -60,3 +60,40 @@ func CorsAll() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// grpc web cors, allowOrigins 为空或包含 "*" 时允许所有 origin
+func CorsGrpcWeb(allowOrigins []string) gin.HandlerFunc {
+	allowAll := len(allowOrigins) == 0
+	allowed := make(map[string]bool)
+	for _, origin := range allowOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[origin] = true
+	}
+	return func(c *gin.Context) {
+		origin := c.Request.Header.Get("Origin")
+		if origin != "" && (allowAll || allowed[origin]) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+			c.Header("Access-Control-Allow-Methods", "POST, OPTIONS")
+			// grpc web 客户端的 header 和自定义 metadata
+			allowHeaders := c.Request.Header.Get("Access-Control-Request-Headers")
+			if allowHeaders == "" {
+				allowHeaders = "Content-Type, X-Grpc-Web, X-User-Agent, Grpc-Timeout, Authorization"
+			}
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			c.Header("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin")
+			c.Header("Access-Control-Max-Age", "172800")
+		} else if origin != "" && c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(403)
+			return
+		}
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
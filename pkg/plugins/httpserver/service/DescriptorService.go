@@ -0,0 +1,17 @@
+package service
+
+import (
+	"synapsor/pkg/plugins/pool/grpc"
+)
+
+type DescriptorService struct{}
+
+// get descriptors of all proxies
+func (s *DescriptorService) GetDescriptors() ([]*grpc.DescriptorsView, error) {
+	return grpc.GetDescriptors(), nil
+}
+
+// refresh descriptors of proxy
+func (s *DescriptorService) RefreshDescriptors(proxyName string) error {
+	return grpc.RefreshDescriptors(proxyName)
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"synapsor/pkg/plugins/pool/grpc"
+)
+
+type FaultService struct{}
+
+// get fault rules
+func (s *FaultService) GetFaultRules() ([]grpc.FaultRuleView, error) {
+	return grpc.GetFaultRules(), nil
+}
+
+// enable or disable fault rule
+func (s *FaultService) SetFaultRuleEnabled(proxyName, ruleName string, enabled bool) error {
+	return grpc.SetFaultRuleEnabled(proxyName, ruleName, enabled)
+}
@@ -0,0 +1,12 @@
+package service
+
+import (
+	"synapsor/pkg/plugins/pool/grpc"
+)
+
+type HealthService struct{}
+
+// whether synapsor is ready to serve
+func (s *HealthService) Ready() bool {
+	return grpc.Ready()
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"net/http"
 	"synapsor/pkg/plugins/httpserver/model"
 	"synapsor/pkg/plugins/metrics"
+	"synapsor/pkg/plugins/pool/grpc"
 )
 
 type MetricsService struct {
@@ -20,3 +22,11 @@ func (s *MetricsService) getSvc() *MetricsService {
 func (s *MetricsService) GetMetricsData() (map[string]map[string]int, error) {
 	return metrics.PoolMetrics(), nil
 }
+
+func (s *MetricsService) GetMirrorData() (map[string]map[string]map[string]grpc.MirrorStat, error) {
+	return metrics.MirrorMetrics(), nil
+}
+
+func (s *MetricsService) GetPrometheusHandler() http.Handler {
+	return metrics.PrometheusHandler()
+}
@@ -0,0 +1,18 @@
+package service
+
+import (
+	"net/http"
+	"synapsor/pkg/plugins/pool/grpc"
+)
+
+type TranscodeService struct{}
+
+// get transcode routes
+func (s *TranscodeService) GetTranscodeRoutes() ([]*grpc.TranscodeRoute, error) {
+	return grpc.GetTranscodeRoutes(), nil
+}
+
+// transcode http request to grpc, false if no route matched
+func (s *TranscodeService) Transcode(w http.ResponseWriter, r *http.Request) bool {
+	return grpc.TranscodeHTTP(w, r)
+}
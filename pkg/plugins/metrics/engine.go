@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"net/http"
 	"strings"
 	"synapsor/pkg/plugins"
 	"synapsor/pkg/plugins/httpserver/util"
@@ -17,6 +18,16 @@ func PoolMetrics() map[string]map[string]int {
 	return grpc.GetConnPoolMetricsData()
 }
 
+// mirror metrics
+func MirrorMetrics() map[string]map[string]map[string]grpc.MirrorStat {
+	return grpc.GetMirrorMetricsData()
+}
+
+// prometheus metrics handler
+func PrometheusHandler() http.Handler {
+	return grpc.MetricsHandler()
+}
+
 // metrics server
 func (plugin *Plugin) ShowMetrics() {
 	// get gRPC port
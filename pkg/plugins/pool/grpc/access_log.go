@@ -0,0 +1,239 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	mrand "math/rand"
+	"os"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// access log format
+const (
+	ACCESS_LOG_JSON   = "json"
+	ACCESS_LOG_LOGFMT = "logfmt"
+)
+
+var (
+	accessLogOnce   sync.Once
+	accessLogWriter io.Writer
+	accessLogFormat string
+	accessLogSample float64
+)
+
+// AccessEntry 一次代理调用的访问日志
+type AccessEntry struct {
+	Time        string  `json:"time"`
+	RequestId   string  `json:"request_id"`
+	Peer        string  `json:"peer"`
+	Method      string  `json:"method"`
+	Proxy       string  `json:"proxy"`
+	Endpoint    string  `json:"endpoint"`
+	Code        string  `json:"code"`
+	DurationMs  float64 `json:"duration_ms"`
+	MessagesIn  int64   `json:"messages_in"`
+	MessagesOut int64   `json:"messages_out"`
+	BytesIn     int64   `json:"bytes_in"`
+	BytesOut    int64   `json:"bytes_out"`
+	Retries     int64   `json:"retries"`
+}
+
+// statistics of a proxied call
+type callStats struct {
+	proxy       string
+	messagesIn  int64
+	messagesOut int64
+	bytesIn     int64
+	bytesOut    int64
+	retries     int64
+	endpoint    atomic.Value
+}
+
+// server stream counting messages and bytes, context carries the server span
+type statsServerStream struct {
+	grpc.ServerStream
+	stats *callStats
+	ctx   context.Context
+}
+
+// context of call
+func (s *statsServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// receive message from client
+func (s *statsServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.stats.messagesIn, 1)
+		if f, ok := m.(*frame); ok {
+			atomic.AddInt64(&s.stats.bytesIn, int64(len(f.payload)))
+		}
+	}
+	return err
+}
+
+// send message to client
+func (s *statsServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.stats.messagesOut, 1)
+		if f, ok := m.(*frame); ok {
+			atomic.AddInt64(&s.stats.bytesOut, int64(len(f.payload)))
+		}
+	}
+	return err
+}
+
+// record selected endpoint of client
+func (stats *callStats) selectEndpoint(conn *Client) {
+	if conn != nil && conn.pool != nil {
+		stats.endpoint.Store(conn.pool.poolRemoteAddr)
+	}
+}
+
+// record a retry attempt
+func (stats *callStats) retry() {
+	atomic.AddInt64(&stats.retries, 1)
+}
+
+// copy endpoint and retries of a backend call made for this call
+func (stats *callStats) copyBackend(from *callStats) {
+	if endpoint := from.endpoint.Load(); endpoint != nil {
+		stats.endpoint.Store(endpoint)
+	}
+	atomic.StoreInt64(&stats.retries, atomic.LoadInt64(&from.retries))
+}
+
+// load access log config, env overrides config file
+func loadAccessLog() {
+	enabled := true
+	if accessLogEnabled := os.Getenv("ACCESS_LOG_ENABLED"); accessLogEnabled != "" {
+		enabled, _ = strconv.ParseBool(accessLogEnabled)
+	} else if viper.IsSet("ACCESS_LOG_ENABLED") {
+		enabled = viper.GetBool("ACCESS_LOG_ENABLED")
+	}
+	if !enabled {
+		return
+	}
+	accessLogPath := os.Getenv("ACCESS_LOG_PATH")
+	if accessLogPath == "" {
+		accessLogPath = viper.GetString("ACCESS_LOG_PATH")
+	}
+	if accessLogPath == "" {
+		accessLogPath = "./logs/log_access.log"
+	}
+	accessLogFormat = os.Getenv("ACCESS_LOG_FORMAT")
+	if accessLogFormat == "" {
+		accessLogFormat = viper.GetString("ACCESS_LOG_FORMAT")
+	}
+	accessLogFormat = strings.ToLower(accessLogFormat)
+	if accessLogFormat != ACCESS_LOG_LOGFMT {
+		accessLogFormat = ACCESS_LOG_JSON
+	}
+	accessLogSample = 1
+	sampleRate := os.Getenv("ACCESS_LOG_SAMPLE_RATE")
+	if sampleRate == "" && viper.IsSet("ACCESS_LOG_SAMPLE_RATE") {
+		accessLogSample = viper.GetFloat64("ACCESS_LOG_SAMPLE_RATE")
+	} else if rate, err := strconv.ParseFloat(sampleRate, 64); err == nil {
+		accessLogSample = rate
+	}
+	accessLogWriter = logging.NewWriter(accessLogPath)
+}
+
+// request id of call, from x-request-id metadata or generated
+func requestId(md metadata.MD) string {
+	if ids := md.Get("x-request-id"); len(ids) > 0 && ids[0] != "" {
+		return ids[0]
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// write access log of the call, failed calls are always written regardless of sampling
+func writeAccessLog(ctx context.Context, fullMethodName string, start time.Time, stats *callStats, err error) {
+	accessLogOnce.Do(loadAccessLog)
+	if accessLogWriter == nil {
+		return
+	}
+	code := status.Code(err)
+	if err == nil && accessLogSample < 1 && mrand.Float64() >= accessLogSample {
+		return
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	entry := &AccessEntry{
+		Time:        start.Format(time.RFC3339Nano),
+		RequestId:   requestId(md),
+		Method:      fullMethodName,
+		Proxy:       stats.proxy,
+		Code:        code.String(),
+		DurationMs:  float64(time.Since(start).Microseconds()) / 1000,
+		MessagesIn:  atomic.LoadInt64(&stats.messagesIn),
+		MessagesOut: atomic.LoadInt64(&stats.messagesOut),
+		BytesIn:     atomic.LoadInt64(&stats.bytesIn),
+		BytesOut:    atomic.LoadInt64(&stats.bytesOut),
+		Retries:     atomic.LoadInt64(&stats.retries),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		entry.Peer = p.Addr.String()
+	}
+	if endpoint, ok := stats.endpoint.Load().(string); ok {
+		entry.Endpoint = endpoint
+	}
+
+	var line []byte
+	if accessLogFormat == ACCESS_LOG_LOGFMT {
+		line = entry.logfmt()
+	} else {
+		line, _ = json.Marshal(entry)
+	}
+	if _, err := accessLogWriter.Write(append(line, '\n')); err != nil {
+		logging.ERROR.Error("write access log: ", err)
+	}
+}
+
+// format entry as logfmt
+func (entry *AccessEntry) logfmt() []byte {
+	var buf bytes.Buffer
+	field := func(key, value string) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if value == "" || strings.ContainsAny(value, " =\"\\\t\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	field("time", entry.Time)
+	field("request_id", entry.RequestId)
+	field("peer", entry.Peer)
+	field("method", entry.Method)
+	field("proxy", entry.Proxy)
+	field("endpoint", entry.Endpoint)
+	field("code", entry.Code)
+	field("duration_ms", strconv.FormatFloat(entry.DurationMs, 'f', 3, 64))
+	field("messages_in", strconv.FormatInt(entry.MessagesIn, 10))
+	field("messages_out", strconv.FormatInt(entry.MessagesOut, 10))
+	field("bytes_in", strconv.FormatInt(entry.BytesIn, 10))
+	field("bytes_out", strconv.FormatInt(entry.BytesOut, 10))
+	field("retries", strconv.FormatInt(entry.Retries, 10))
+	return buf.Bytes()
+}
@@ -0,0 +1,383 @@
+package grpc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// response cache backend
+const (
+	CACHE_BACKEND_MEMORY = "memory"
+	CACHE_BACKEND_REDIS  = "redis"
+)
+
+// response header of cache status
+const (
+	CACHE_STATUS_HEADER = "x-synapsor-cache"
+	CACHE_HIT           = "HIT"
+	CACHE_MISS          = "MISS"
+	CACHE_BYPASS        = "BYPASS"
+)
+
+// redis client of response cache, set by SetCacheClient
+var cacheRedis redis.Cmdable
+
+// MethodCache 方法的响应缓存, key 为方法、请求消息和 METADATA 中的 metadata
+type MethodCache struct {
+	TTL      time.Duration
+	Metadata []string
+}
+
+// cached response
+type cacheEntry struct {
+	Header   metadata.MD `json:"header"`
+	Payload  []byte      `json:"payload"`
+	Trailer  metadata.MD `json:"trailer"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// response cache store
+type responseCache interface {
+	get(key string) (*cacheEntry, bool)
+	set(key string, entry *cacheEntry, ttl time.Duration)
+}
+
+// set redis client of response cache
+func SetCacheClient(client redis.Cmdable) {
+	cacheRedis = client
+}
+
+// new response cache of proxy
+func newResponseCache(proxyName, backend string, maxEntries int) (responseCache, error) {
+	switch strings.ToLower(backend) {
+	case CACHE_BACKEND_MEMORY:
+		return &memoryCache{maxEntries: maxEntries, items: make(map[string]*list.Element), order: list.New()}, nil
+	case CACHE_BACKEND_REDIS:
+		if cacheRedis == nil {
+			logging.ERROR.Error("response cache of proxy ", proxyName, " uses redis, but redis cache is not enabled")
+		}
+		return &redisCache{prefix: "synapsor:cache:" + proxyName + ":"}, nil
+	}
+	return nil, ErrServicesInvalid
+}
+
+// in-memory lru cache
+type memoryCache struct {
+	maxEntries int
+	lock       sync.Mutex
+	items      map[string]*list.Element
+	order      *list.List
+}
+
+// lru item
+type memoryItem struct {
+	key      string
+	entry    *cacheEntry
+	expireAt time.Time
+}
+
+// get entry and move it to front
+func (c *memoryCache) get(key string) (*cacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*memoryItem)
+	if time.Now().After(item.expireAt) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return item.entry, true
+}
+
+// set entry, the least recently used entry is evicted when full
+func (c *memoryCache) set(key string, entry *cacheEntry, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	item := &memoryItem{key: key, entry: entry, expireAt: time.Now().Add(ttl)}
+	if element, ok := c.items[key]; ok {
+		element.Value = item
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(item)
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryItem).key)
+	}
+}
+
+// redis cache, entries are stored as json
+type redisCache struct {
+	prefix string
+}
+
+// get entry
+func (c *redisCache) get(key string) (*cacheEntry, bool) {
+	if cacheRedis == nil {
+		return nil, false
+	}
+	data, err := cacheRedis.Get(c.prefix + key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logging.ERROR.Error("get response cache: ", err)
+		}
+		return nil, false
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// set entry
+func (c *redisCache) set(key string, entry *cacheEntry, ttl time.Duration) {
+	if cacheRedis == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := cacheRedis.Set(c.prefix+key, data, ttl).Err(); err != nil {
+		logging.ERROR.Error("set response cache: ", err)
+	}
+}
+
+// cache-control directives of metadata
+type cacheControl struct {
+	noCache bool
+	noStore bool
+	maxAge  time.Duration // -1 if not set
+}
+
+// parse cache-control values, e.g. no-cache, max-age=60
+func parseCacheControl(values []string) cacheControl {
+	control := cacheControl{maxAge: -1}
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			switch {
+			case directive == "no-cache":
+				control.noCache = true
+			case directive == "no-store" || directive == "private":
+				control.noStore = true
+			case strings.HasPrefix(directive, "max-age="):
+				if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds >= 0 {
+					control.maxAge = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+	}
+	return control
+}
+
+// metadata of the cache key, credentials of the call are always included
+// so responses are not shared between callers
+func (policy *MethodPolicy) keyMetadata(keys []string) []string {
+	credentials := []string{"authorization"}
+	if policy.Auth != nil {
+		credentials = append(credentials, policy.Auth.Metadata)
+	}
+	result := append([]string(nil), keys...)
+	for _, k := range credentials {
+		found := false
+		for _, key := range result {
+			found = found || key == k
+		}
+		if !found {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+// cache key of request
+func cacheKey(fullMethodName string, payload []byte, md metadata.MD, keys []string) string {
+	h := sha256.New()
+	h.Write([]byte(fullMethodName))
+	h.Write([]byte{0})
+	h.Write(payload)
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		h.Write([]byte{0})
+		h.Write([]byte(k + "=" + strings.Join(md.Get(k), ",")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// server stream recording the response sent to client
+type responseRecorder struct {
+	grpc.ServerStream
+	mark     metadata.MD // added to the sent header
+	header   metadata.MD
+	trailer  metadata.MD
+	payloads [][]byte
+}
+
+// recording stream of a cache miss
+type cachedCall struct {
+	*responseRecorder
+	store   responseCache
+	key     string
+	ttl     time.Duration
+	noStore bool
+}
+
+// look up response cache of unary method, the response is sent if hit,
+// otherwise returns the recording stream and the request frame is delivered by first again
+func startCachedCall(serverStream grpc.ServerStream, first *<-chan recvResult, fullMethodName string, policy *MethodPolicy) (*cachedCall, bool, error) {
+	if policy == nil || policy.Cache == nil {
+		return nil, false, nil
+	}
+	md, _ := metadata.FromIncomingContext(serverStream.Context())
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok {
+		return nil, false, nil
+	}
+	connLock.RLock()
+	services, _ := connProxy[proxyName]["services"].(*ProxyServices)
+	connLock.RUnlock()
+	if services == nil || services.cache == nil {
+		return nil, false, nil
+	}
+	// only unary methods known by descriptors are cached
+	if !unaryMethod(proxyName, fullMethodName) {
+		return nil, false, nil
+	}
+	f, err := peekRequest(serverStream, first)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	control := parseCacheControl(md.Get("cache-control"))
+	call := &cachedCall{
+		responseRecorder: newResponseRecorder(serverStream, CACHE_STATUS_HEADER, CACHE_MISS),
+		store:            services.cache,
+		key:              cacheKey(fullMethodName, f.payload, md, policy.keyMetadata(policy.Cache.Metadata)),
+		ttl:              policy.Cache.TTL,
+		noStore:          control.noStore,
+	}
+	if control.noCache || control.noStore {
+		call.mark.Set(CACHE_STATUS_HEADER, CACHE_BYPASS)
+		return call, false, nil
+	}
+	entry, ok := services.cache.get(call.key)
+	if !ok || (control.maxAge >= 0 && time.Since(entry.StoredAt) > control.maxAge) {
+		return call, false, nil
+	}
+
+	// cache hit, request is consumed and backend is not called
+	<-*first
+	header := entry.Header.Copy()
+	header.Set(CACHE_STATUS_HEADER, CACHE_HIT)
+	if err := serverStream.SendHeader(header); err != nil {
+		return nil, true, err
+	}
+	if err := serverStream.SendMsg(&frame{payload: entry.Payload}); err != nil {
+		return nil, true, err
+	}
+	serverStream.SetTrailer(entry.Trailer)
+	return nil, true, nil
+}
+
+// whether method is unary by descriptors of proxy
+func unaryMethod(proxyName, fullMethodName string) bool {
+	method, err := resolveMethod(proxyName, fullMethodName)
+	return err == nil && !method.IsStreamingClient() && !method.IsStreamingServer()
+}
+
+// read the request frame, it is delivered by first again
+func peekRequest(serverStream grpc.ServerStream, first *<-chan recvResult) (*frame, error) {
+	f := &frame{}
+	err := recvFrame(serverStream, first, f)
+	requeue := make(chan recvResult, 1)
+	requeue <- recvResult{frame: f, err: err}
+	*first = requeue
+	return f, err
+}
+
+// new response recorder, the header key is set to value in the sent header
+func newResponseRecorder(serverStream grpc.ServerStream, key, value string) *responseRecorder {
+	return &responseRecorder{ServerStream: serverStream, mark: metadata.Pairs(key, value)}
+}
+
+// record header, the sent header is marked
+func (r *responseRecorder) SendHeader(md metadata.MD) error {
+	r.header = md.Copy()
+	header := md.Copy()
+	for k, v := range r.mark {
+		header.Set(k, v...)
+	}
+	return r.ServerStream.SendHeader(header)
+}
+
+// record response message
+func (r *responseRecorder) SendMsg(m interface{}) error {
+	if f, ok := m.(*frame); ok {
+		r.payloads = append(r.payloads, append([]byte(nil), f.payload...))
+	}
+	return r.ServerStream.SendMsg(m)
+}
+
+// record trailer
+func (r *responseRecorder) SetTrailer(md metadata.MD) {
+	r.trailer = metadata.Join(r.trailer, md)
+	r.ServerStream.SetTrailer(md)
+}
+
+// store response of successful call, cache-control of response header can disable or limit caching
+func (call *cachedCall) finish(err error) {
+	if err != nil || call.noStore || len(call.payloads) != 1 {
+		return
+	}
+	ttl := call.ttl
+	control := parseCacheControl(call.header.Get("cache-control"))
+	if control.noStore || control.noCache {
+		return
+	}
+	if control.maxAge >= 0 && control.maxAge < ttl {
+		ttl = control.maxAge
+	}
+	if ttl <= 0 {
+		return
+	}
+	call.store.set(call.key, &cacheEntry{
+		Header:   call.header,
+		Payload:  call.payloads[0],
+		Trailer:  call.trailer,
+		StoredAt: time.Now(),
+	}, ttl)
+}
+
+// whether the proxy has cached or coalesced methods, descriptors are needed to know unary methods
+func (services *ProxyServices) needsUnary() bool {
+	if services == nil {
+		return false
+	}
+	for _, policy := range services.policies() {
+		if policy.Cache != nil || policy.Coalesce != nil {
+			return true
+		}
+	}
+	return false
+}
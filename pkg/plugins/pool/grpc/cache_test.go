@@ -0,0 +1,26 @@
+package grpc
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCacheKeyCredentials(t *testing.T) {
+	policy := &MethodPolicy{Auth: &MethodAuth{Metadata: "x-api-key", Values: []string{"a", "b"}}}
+	key := func(md metadata.MD) string {
+		return cacheKey("/hello.Greeter/GetUser", []byte("req"), md, policy.keyMetadata(nil))
+	}
+	if key(metadata.Pairs("authorization", "alice")) == key(metadata.Pairs("authorization", "bob")) {
+		t.Error("calls with different authorization share the key")
+	}
+	if key(metadata.Pairs("x-api-key", "a")) == key(metadata.Pairs("x-api-key", "b")) {
+		t.Error("calls with different auth metadata share the key")
+	}
+	if key(metadata.Pairs("x-trace", "1")) != key(metadata.Pairs("x-trace", "2")) {
+		t.Error("metadata not in the key changes the key")
+	}
+	if keys := policy.keyMetadata([]string{"authorization", "x-tenant-id"}); len(keys) != 3 {
+		t.Errorf("key metadata %v", keys)
+	}
+}
@@ -0,0 +1,169 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// default timeout of coalesced backend call
+var CoalesceTimeout = 10 * time.Second
+
+// MethodCoalesce 合并进行中的相同 unary 调用, key 为方法、请求消息和 METADATA 中的 metadata
+type MethodCoalesce struct {
+	Timeout  time.Duration
+	Metadata []string
+}
+
+// backend call shared by identical in-flight requests
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	stats   *callStats // 共享后端调用的 endpoint 和重试次数
+	header  metadata.MD
+	payload *frame
+	trailer metadata.MD
+	err     error
+}
+
+var (
+	flights     = make(map[string]*flight)
+	flightsLock sync.Mutex
+)
+
+// context with values of parent, not canceled with parent
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// join or start the backend call of identical requests, false if the method is not coalesced
+func (s *handler) coalesce(ctx context.Context, serverStream grpc.ServerStream, first *<-chan recvResult, fullMethodName string, policy *MethodPolicy, stats *callStats) (bool, error) {
+	if policy == nil || policy.Coalesce == nil {
+		return false, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok || !unaryMethod(proxyName, fullMethodName) {
+		return false, nil
+	}
+	request, err := peekRequest(serverStream, first)
+	if err != nil {
+		return false, nil
+	}
+	<-*first
+	key := proxyName + ":" + cacheKey(fullMethodName, request.payload, md, policy.keyMetadata(policy.Coalesce.Metadata))
+
+	flightsLock.Lock()
+	f, ok := flights[key]
+	if !ok {
+		// the backend call outlives the caller who starts it, other callers may still wait
+		timeout := policy.Coalesce.Timeout
+		if timeout <= 0 {
+			timeout = CoalesceTimeout
+		}
+		callCtx, cancel := context.WithTimeout(detachedContext{ctx}, timeout)
+		// the backend call has its own stats, the starting caller may return before it ends
+		f = &flight{done: make(chan struct{}), cancel: cancel, stats: &callStats{proxy: stats.proxy}}
+		flights[key] = f
+		go func() {
+			defer cancel()
+			f.header, f.payload, f.trailer, f.err = s.unaryCall(callCtx, fullMethodName, request, policy, f.stats)
+			flightsLock.Lock()
+			if flights[key] == f {
+				delete(flights, key)
+			}
+			flightsLock.Unlock()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	flightsLock.Unlock()
+	observeCoalesce(stats.proxy, fullMethodName, ok)
+	if ok {
+		// the joined backend call may have reached backend
+		idempotentStreamOpened(ctx)
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		// the backend call is canceled when no caller waits for it
+		flightsLock.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			if flights[key] == f {
+				delete(flights, key)
+			}
+		}
+		flightsLock.Unlock()
+		return true, status.FromContextError(ctx.Err()).Err()
+	}
+	stats.copyBackend(f.stats)
+	if f.header != nil {
+		if err := serverStream.SendHeader(f.header.Copy()); err != nil {
+			return true, err
+		}
+	}
+	if f.payload != nil {
+		if err := serverStream.SendMsg(f.payload); err != nil {
+			return true, err
+		}
+	}
+	serverStream.SetTrailer(f.trailer)
+	return true, f.err
+}
+
+// call unary method with retry of method policy
+func (s *handler) unaryCall(ctx context.Context, fullMethodName string, request *frame, policy *MethodPolicy, stats *callStats) (metadata.MD, *frame, metadata.MD, error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			stats.retry()
+		}
+		clientStream, response, conn, clientCancel, span, err := s.attempt(ctx, fullMethodName, []*frame{request}, attempt)
+		stats.selectEndpoint(conn)
+		if err == nil {
+			header, _ := clientStream.Header()
+			// wait for status of the call
+			if response != nil {
+				if err = clientStream.RecvMsg(&frame{}); err == io.EOF {
+					err = nil
+				} else if err == nil {
+					err = status.Errorf(codes.Internal, "more than one response of unary method %s", fullMethodName)
+				}
+			}
+			trailer := clientStream.Trailer()
+			endSpan(span, err)
+			clientCancel()
+			conn.Close()
+			return header, response, trailer, err
+		}
+		if !policy.retryable() || attempt >= policy.Retry || !policy.retryOn(err) {
+			return nil, nil, nil, err
+		}
+		select {
+		case <-time.After(policy.RetryBackoff * time.Duration(attempt+1)):
+		case <-ctx.Done():
+			return nil, nil, nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
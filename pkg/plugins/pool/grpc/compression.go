@@ -0,0 +1,238 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+)
+
+// compression algorithm of backend calls
+const (
+	COMPRESSION_GZIP   = gzip.Name
+	COMPRESSION_ZSTD   = "zstd"
+	COMPRESSION_SNAPPY = "snappy"
+)
+
+// CompressionPolicy 发往后端的消息压缩, 和客户端使用的压缩无关
+type CompressionPolicy struct {
+	Algorithm string // gzip、zstd、snappy
+	MinSize   int    // 请求消息小于 MinSize 字节的调用不压缩, 只对 unary 和 server streaming 调用生效
+	Streams   bool   // 是否压缩 client streaming 和双向流调用, 压缩开启后流中的每条消息都压缩
+}
+
+func init() {
+	// clients and backends can use zstd and snappy besides gzip
+	encoding.RegisterCompressor(&zstdCompressor{})
+	encoding.RegisterCompressor(&snappyCompressor{})
+}
+
+// parse proxy compression policy, nil if not compressed
+func parseCompressionPolicy(proxyMap map[string]interface{}) *CompressionPolicy {
+	compressionMap := configMap(proxyMap, "COMPRESSION")
+	if compressionMap == nil {
+		return nil
+	}
+	policy := &CompressionPolicy{
+		Algorithm: strings.ToLower(configString(compressionMap, "ALGORITHM", "")),
+		MinSize:   configInt(compressionMap, "MIN_SIZE", 1024),
+		Streams:   configBool(compressionMap, "STREAMS", true),
+	}
+	switch policy.Algorithm {
+	case COMPRESSION_GZIP, COMPRESSION_ZSTD, COMPRESSION_SNAPPY:
+		return policy
+	case "", "identity":
+		return nil
+	}
+	logging.ERROR.Error("unknown compression algorithm ", policy.Algorithm, ", backend calls are not compressed")
+	return nil
+}
+
+// compression policy of proxy
+func proxyCompression(ctx context.Context, fullMethodName string) (*CompressionPolicy, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok {
+		return nil, proxyName
+	}
+	connLock.RLock()
+	defer connLock.RUnlock()
+	policy, _ := connProxy[proxyName]["compression"].(*CompressionPolicy)
+	return policy, proxyName
+}
+
+// call options of backend compression, size is the request message size, -1 if unknown
+func (policy *CompressionPolicy) callOptions(size int) []grpc.CallOption {
+	if policy == nil || (size >= 0 && size < policy.MinSize) {
+		return nil
+	}
+	return []grpc.CallOption{grpc.UseCompressor(policy.Algorithm)}
+}
+
+// call options of backend compression of forwarded stream, the request of method with one request
+// message is read in advance to compare with MIN_SIZE, compressor of grpc applies to all messages of
+// a stream, so client streams are compressed by STREAMS regardless of message size
+func streamCompression(serverStream grpc.ServerStream, first *<-chan recvResult, fullMethodName string) []grpc.CallOption {
+	policy, proxyName := proxyCompression(serverStream.Context(), fullMethodName)
+	if policy == nil {
+		return nil
+	}
+	if !retryableMethod(proxyName, fullMethodName) {
+		if !policy.Streams {
+			return nil
+		}
+		return policy.callOptions(-1)
+	}
+	if policy.MinSize <= 0 {
+		return policy.callOptions(-1)
+	}
+	f, err := peekRequest(serverStream, first)
+	if err != nil {
+		return nil
+	}
+	return policy.callOptions(len(f.payload))
+}
+
+// max payload size of request frames
+func requestSize(requests []*frame) int {
+	size := 0
+	for _, f := range requests {
+		if len(f.payload) > size {
+			size = len(f.payload)
+		}
+	}
+	return size
+}
+
+// zstd compressor, encoders and decoders are reused
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+// zstd writer returns encoder to pool when closed
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+// zstd reader returns decoder to pool at the end of message
+type zstdReader struct {
+	decoder *zstd.Decoder
+	pool    *sync.Pool
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if encoder, ok := c.encoders.Get().(*zstd.Encoder); ok {
+		encoder.Reset(w)
+		return &zstdWriter{Encoder: encoder, pool: &c.encoders}, nil
+	}
+	encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{Encoder: encoder, pool: &c.encoders}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	if decoder, ok := c.decoders.Get().(*zstd.Decoder); ok {
+		if err := decoder.Reset(r); err != nil {
+			c.decoders.Put(decoder)
+			return nil, err
+		}
+		return &zstdReader{decoder: decoder, pool: &c.decoders}, nil
+	}
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{decoder: decoder, pool: &c.decoders}, nil
+}
+
+func (c *zstdCompressor) Name() string {
+	return COMPRESSION_ZSTD
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w.Encoder)
+	return err
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.decoder == nil {
+		return 0, io.EOF
+	}
+	n, err := r.decoder.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.decoder)
+		r.decoder = nil
+	}
+	return n, err
+}
+
+// snappy compressor of framing format
+type snappyCompressor struct {
+	writers sync.Pool
+	readers sync.Pool
+}
+
+// snappy writer returns itself to pool when closed
+type snappyWriter struct {
+	*s2.Writer
+	pool *sync.Pool
+}
+
+// snappy reader returns itself to pool at the end of message
+type snappyReader struct {
+	reader *s2.Reader
+	pool   *sync.Pool
+}
+
+func (c *snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	writer, ok := c.writers.Get().(*snappyWriter)
+	if !ok {
+		writer = &snappyWriter{Writer: s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1)), pool: &c.writers}
+	}
+	writer.Reset(w)
+	return writer, nil
+}
+
+func (c *snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	reader, ok := c.readers.Get().(*s2.Reader)
+	if !ok {
+		reader = s2.NewReader(r)
+	}
+	reader.Reset(r)
+	return &snappyReader{reader: reader, pool: &c.readers}, nil
+}
+
+func (c *snappyCompressor) Name() string {
+	return COMPRESSION_SNAPPY
+}
+
+func (w *snappyWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w)
+	return err
+}
+
+func (r *snappyReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		return 0, io.EOF
+	}
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r.reader)
+		r.reader = nil
+	}
+	return n, err
+}
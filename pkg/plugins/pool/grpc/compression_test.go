@@ -0,0 +1,115 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+	for _, name := range []string{COMPRESSION_ZSTD, COMPRESSION_SNAPPY} {
+		compressor := encoding.GetCompressor(name)
+		if compressor == nil {
+			t.Fatalf("compressor %s not registered", name)
+		}
+		// writers and readers are reused from pool, messages must not leak into each other
+		for i, message := range []string{strings.Repeat("hello ", 1000), "", "world", strings.Repeat("x", 1<<16)} {
+			var buf bytes.Buffer
+			w, err := compressor.Compress(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(message)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := compressor.Decompress(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != message {
+				t.Errorf("%s message %d: got %d bytes, want %d", name, i, len(data), len(message))
+			}
+			// reading after the end of message returns EOF
+			if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Errorf("%s message %d: read after end %d %v", name, i, n, err)
+			}
+		}
+	}
+}
+
+func TestParseCompressionPolicy(t *testing.T) {
+	policy := parseCompressionPolicy(map[string]interface{}{
+		"COMPRESSION": map[string]interface{}{"ALGORITHM": "ZSTD", "STREAMS": false},
+	})
+	if policy == nil || policy.Algorithm != COMPRESSION_ZSTD || policy.MinSize != 1024 || policy.Streams {
+		t.Errorf("policy %+v", policy)
+	}
+	if policy.callOptions(100) != nil || len(policy.callOptions(2048)) != 1 || len(policy.callOptions(-1)) != 1 {
+		t.Errorf("call options by size")
+	}
+	if policy := parseCompressionPolicy(map[string]interface{}{"COMPRESSION": map[string]interface{}{"ALGORITHM": "lz4"}}); policy != nil {
+		t.Errorf("unknown algorithm policy %+v", policy)
+	}
+}
+
+// server stream of context only, the request is delivered by the first channel
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamCompression(t *testing.T) {
+	testProxy(t, "compress", map[string]interface{}{
+		"compression": &CompressionPolicy{Algorithm: COMPRESSION_ZSTD, MinSize: 16, Streams: true},
+	})
+	testDescriptors(t, "compress", healthpb.File_grpc_health_v1_health_proto, rpb.File_grpc_reflection_v1alpha_reflection_proto)
+
+	stream := &contextServerStream{ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("proxy", "compress"))}
+	compressed := func(fullMethodName string, size int) bool {
+		requests := make(chan recvResult, 1)
+		requests <- recvResult{frame: &frame{payload: make([]byte, size)}}
+		first := (<-chan recvResult)(requests)
+		options := streamCompression(stream, &first, fullMethodName)
+		// the peeked request is delivered again
+		if result := <-first; result.err != nil || len(result.frame.payload) != size {
+			t.Errorf("%s request not delivered", fullMethodName)
+		}
+		return len(options) > 0
+	}
+	// MIN_SIZE applies to methods with one request message
+	for _, method := range []string{"/grpc.health.v1.Health/Check", "/grpc.health.v1.Health/Watch"} {
+		if compressed(method, 8) || !compressed(method, 32) {
+			t.Errorf("%s compressed regardless of MIN_SIZE", method)
+		}
+	}
+	// client streams are compressed by STREAMS
+	info := "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+	if !compressed(info, 8) {
+		t.Errorf("stream not compressed")
+	}
+	connLock.Lock()
+	connProxy["compress"]["compression"] = &CompressionPolicy{Algorithm: COMPRESSION_ZSTD, MinSize: 16, Streams: false}
+	connLock.Unlock()
+	if compressed(info, 32) {
+		t.Errorf("stream compressed with STREAMS false")
+	}
+}
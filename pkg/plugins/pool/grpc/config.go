@@ -0,0 +1,102 @@
+package grpc
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// get config string
+func configString(conf map[string]interface{}, key, defaultValue string) string {
+	v, ok := conf[key]
+	if !ok || v == nil {
+		return defaultValue
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// get config int
+func configInt(conf map[string]interface{}, key string, defaultValue int) int {
+	switch v := conf[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// get config float
+func configFloat(conf map[string]interface{}, key string, defaultValue float64) float64 {
+	switch v := conf[key].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+// get config bool
+func configBool(conf map[string]interface{}, key string, defaultValue bool) bool {
+	switch v := conf[key].(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// get config list
+func configList(conf map[string]interface{}, key string) []interface{} {
+	if v, ok := conf[key].([]interface{}); ok {
+		return v
+	}
+	return nil
+}
+
+// get config string list, false if an item is a list or map
+func configStringList(conf map[string]interface{}, key string) ([]string, bool) {
+	var list []string
+	for _, v := range configList(conf, key) {
+		switch v.(type) {
+		case nil, []interface{}, map[string]interface{}:
+			return nil, false
+		}
+		list = append(list, fmt.Sprintf("%v", v))
+	}
+	return list, true
+}
+
+// get config map
+func configMap(conf map[string]interface{}, key string) map[string]interface{} {
+	if v, ok := conf[key].(map[string]interface{}); ok {
+		return v
+	}
+	return nil
+}
+
+// get config string map
+func configStringMap(conf map[string]interface{}, key string) map[string]string {
+	m := make(map[string]string)
+	for k, v := range configMap(conf, key) {
+		m[k] = fmt.Sprintf("%v", v)
+	}
+	return m
+}
@@ -0,0 +1,190 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	logging "synapsor/pkg/core/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// load file descriptor protos from FileDescriptorSet file (protoc --include_imports --descriptor_set_out)
+func loadDescriptorSet(path string) (map[string]*descriptorpb.FileDescriptorProto, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, fds); err != nil {
+		return nil, err
+	}
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, file := range fds.File {
+		files[file.GetName()] = file
+	}
+	return files, nil
+}
+
+// fetch file descriptor protos of all services and their dependencies by server reflection
+func reflectionFileProtos(ctx context.Context, addr string) (map[string]*descriptorpb.FileDescriptorProto, error) {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	resp, err := reflectionRequest(stream, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		resp, err := reflectionRequest(stream, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service.Name},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := addReflectionFiles(files, resp); err != nil {
+			return nil, err
+		}
+	}
+	// fetch missing dependencies
+	for missing := missingDependency(files); missing != ""; missing = missingDependency(files) {
+		resp, err := reflectionRequest(stream, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: missing},
+		})
+		if err != nil {
+			return nil, err
+		}
+		before := len(files)
+		if err := addReflectionFiles(files, resp); err != nil {
+			return nil, err
+		}
+		if len(files) == before {
+			return nil, fmt.Errorf("reflection file %s not found", missing)
+		}
+	}
+	return files, nil
+}
+
+// send reflection request and receive response
+func reflectionRequest(stream rpb.ServerReflection_ServerReflectionInfoClient, req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err == io.EOF {
+		return nil, errors.New("reflection stream closed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", errResp.ErrorCode, errResp.ErrorMessage)
+	}
+	return resp, nil
+}
+
+// add file descriptors of reflection response
+func addReflectionFiles(files map[string]*descriptorpb.FileDescriptorProto, resp *rpb.ServerReflectionResponse) error {
+	for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		file := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, file); err != nil {
+			return err
+		}
+		files[file.GetName()] = file
+	}
+	return nil
+}
+
+// get a dependency not fetched yet
+func missingDependency(files map[string]*descriptorpb.FileDescriptorProto) string {
+	for _, file := range files {
+		for _, dep := range file.Dependency {
+			if _, ok := files[dep]; !ok {
+				return dep
+			}
+		}
+	}
+	return ""
+}
+
+// message and extension types of descriptors, used to resolve Any in json and extensions
+func descriptorTypes(files *protoregistry.Files) *protoregistry.Types {
+	types := &protoregistry.Types{}
+	registerExtensions := func(extensions protoreflect.ExtensionDescriptors) {
+		for i := 0; i < extensions.Len(); i++ {
+			types.RegisterExtension(dynamicpb.NewExtensionType(extensions.Get(i)))
+		}
+	}
+	var register func(messages protoreflect.MessageDescriptors)
+	register = func(messages protoreflect.MessageDescriptors) {
+		for i := 0; i < messages.Len(); i++ {
+			types.RegisterMessage(dynamicpb.NewMessageType(messages.Get(i)))
+			registerExtensions(messages.Get(i).Extensions())
+			register(messages.Get(i).Messages())
+		}
+	}
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		register(file.Messages())
+		registerExtensions(file.Extensions())
+		return true
+	})
+	return types
+}
+
+// build files registry in dependency order, files conflicting with registered ones are skipped
+func buildFiles(protos map[string]*descriptorpb.FileDescriptorProto) *protoregistry.Files {
+	files := &protoregistry.Files{}
+	pending := make(map[string]*descriptorpb.FileDescriptorProto)
+	for name, file := range protos {
+		pending[name] = file
+	}
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		for name, file := range pending {
+			ready := true
+			for _, dep := range file.Dependency {
+				if _, ok := pending[dep]; ok {
+					ready = false
+					break
+				}
+			}
+			if !ready {
+				continue
+			}
+			delete(pending, name)
+			progress = true
+			fd, err := protodesc.NewFile(file, files)
+			if err == nil {
+				err = files.RegisterFile(fd)
+			}
+			if err != nil {
+				logging.ERROR.Error("register descriptor ", name, " error: ", err)
+			}
+		}
+	}
+	for name := range pending {
+		logging.ERROR.Error("register descriptor ", name, " error: dependency cycle")
+	}
+	return files
+}
@@ -45,17 +45,23 @@ func GrpcProxyTransport(ctx context.Context, fullMethodName string) (context.Con
 	var conn *Client
 	// setting md data
 	md, mdExists := metadata.FromIncomingContext(ctx)
-	outCtx, _ := context.WithCancel(ctx)
-	outCtx = metadata.NewOutgoingContext(outCtx, md.Copy())
+	outCtx := metadata.NewOutgoingContext(ctx, md.Copy())
 
-	if defaultProxy {
-		conn, err = balancePool(connPools[DEFAULT_PROXY], connProxy[DEFAULT_PROXY]["proxyModel"].(string)).Acquire(ctx)
-	} else if mdExists {
-		proxyName, proxyNameExists := md["proxy"]
+	if defaultProxy || mdExists {
+		// request fields are only used to select pools, not forwarded
+		routeMd := routeMetadata(ctx, md)
+		proxyName, proxyNameExists := resolveProxyName(fullMethodName, routeMd)
 		if !proxyNameExists {
 			return nil, nil, nil, status.Errorf(codes.Unimplemented, "proxy name not exist")
 		}
-		conn, err = balancePool(connPools[proxyName[0]], connProxy[proxyName[0]]["proxyModel"].(string)).Acquire(ctx)
+		pools, proxyModel := routePools(proxyName, routeMd)
+		if len(pools) < 1 {
+			return nil, nil, nil, status.Errorf(codes.Unavailable, "no endpoint available for proxy %s", proxyName)
+		}
+		pool := selectPool(proxyName, pools, proxyModel, routeMd)
+		acquireStart := time.Now()
+		conn, err = pool.Acquire(ctx)
+		observeAcquire(proxyName, pool, time.Since(acquireStart))
 	}
 	// conn not nil
 	if conn != nil {
@@ -66,6 +72,60 @@ func GrpcProxyTransport(ctx context.Context, fullMethodName string) (context.Con
 	return nil, nil, nil, status.Errorf(codes.Unimplemented, "unknown method")
 }
 
+// resolve proxy name from request metadata, then xds routes
+func resolveProxyName(fullMethodName string, md metadata.MD) (string, bool) {
+	var proxyName string
+	var ok bool
+	if proxyNames := md.Get("proxy"); !defaultProxy && len(proxyNames) > 0 {
+		proxyName, ok = proxyNames[0], true
+	}
+	if !ok {
+		proxyName, ok = resolveXdsProxy(fullMethodName, md)
+	}
+	if !ok {
+		if !defaultProxy {
+			return "", false
+		}
+		proxyName = DEFAULT_PROXY
+	}
+	connLock.RLock()
+	defer connLock.RUnlock()
+	if _, ok := connProxy[proxyName]; !ok {
+		return "", false
+	}
+	return proxyName, true
+}
+
+// get proxy pools selected by route table and proxy model
+func routePools(proxyName string, md metadata.MD) (map[string]*Pool, string) {
+	connLock.RLock()
+	defer connLock.RUnlock()
+
+	pools := make(map[string]*Pool)
+	for k, pool := range connPools[proxyName] {
+		if pool.status {
+			pools[k] = pool
+		}
+	}
+	proxyModel, _ := connProxy[proxyName]["proxyModel"].(string)
+	if routeTable, ok := connProxy[proxyName]["routeTable"].(*RouteTable); ok {
+		return routeTable.filterPools(pools, md), proxyModel
+	}
+	return pools, proxyModel
+}
+
+// select pool by hash of route table, balance model if no hash value
+func selectPool(proxyName string, pools map[string]*Pool, proxyModel string, md metadata.MD) *Pool {
+	if table := proxyRouteTable(proxyName); table != nil {
+		if key := table.hashValue(md); key != "" {
+			pool := hashPool(pools, key)
+			pool.sumRequestTimes += 1
+			return pool
+		}
+	}
+	return balancePool(pools, proxyModel)
+}
+
 // gRPC proxy
 func balancePool(pools map[string]*Pool, proxyModel string) *Pool {
 	// var sumSize, size int
@@ -139,11 +199,18 @@ func weightedRandomIndex(weights []float32) int {
 func randomWeightBalance(pools map[string]*Pool) string {
 	var weights = []float32{}
 	var indexRand []string
+	// static endpoints are weighted by capacity, discovered endpoints by the weight
+	// from the provider which is updated under connLock
+	connLock.RLock()
 	for k, pool := range pools {
 		weight := pool.capacity
+		if pool.discovered && pool.weight > 0 {
+			weight = pool.weight
+		}
 		weights = append(weights, float32(weight))
 		indexRand = append(indexRand, k)
 	}
+	connLock.RUnlock()
 
 	poolIndex := indexRand[weightedRandomIndex(weights)]
 	vsDebug := os.Getenv("VS_DEBUG")
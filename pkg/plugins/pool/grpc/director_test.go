@@ -0,0 +1,28 @@
+package grpc
+
+import "testing"
+
+func TestRandomWeightBalance(t *testing.T) {
+	// static endpoints keep capacity weighting, proxy weight is ignored
+	static := map[string]*Pool{
+		"a": {capacity: 0, weight: 100},
+		"b": {capacity: 5, weight: 0},
+	}
+	// discovered endpoints are weighted by the provider weight
+	discovered := map[string]*Pool{
+		"a": {capacity: 100, weight: 1, discovered: true},
+		"b": {capacity: 1, weight: 100, discovered: true},
+	}
+	picked := 0
+	for i := 0; i < 1000; i++ {
+		if k := randomWeightBalance(static); k != "b" {
+			t.Fatalf("static picked %s, want b", k)
+		}
+		if randomWeightBalance(discovered) == "b" {
+			picked++
+		}
+	}
+	if picked < 900 {
+		t.Errorf("discovered picked b %d of 1000", picked)
+	}
+}
@@ -0,0 +1,138 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"time"
+)
+
+// discovery type
+const (
+	DISCOVERY_KUBERNETES = "kubernetes"
+	DISCOVERY_DNS        = "dns"
+	DISCOVERY_FILE       = "file"
+	DISCOVERY_HTTP       = "http"
+)
+
+// DiscoveryProvider 服务发现 provider, 发现的 endpoints 全量通过 update 同步到连接池
+type DiscoveryProvider interface {
+	Run(ctx context.Context, update func([]Endpoint)) error
+}
+
+// DiscoveryFactory 根据 proxy 的 DISCOVERY 配置创建 provider
+type DiscoveryFactory func(proxyName string, conf map[string]interface{}) (DiscoveryProvider, error)
+
+// discovery provider factories
+var discoveryFactories = map[string]DiscoveryFactory{
+	DISCOVERY_KUBERNETES: func(proxyName string, conf map[string]interface{}) (DiscoveryProvider, error) {
+		client, err := newKubernetesClient(configString(conf, "KUBECONFIG", ""))
+		if err != nil {
+			return nil, err
+		}
+		return NewKubernetesDiscovery(proxyName, conf, client), nil
+	},
+	DISCOVERY_DNS: func(proxyName string, conf map[string]interface{}) (DiscoveryProvider, error) {
+		return NewDNSDiscovery(proxyName, conf), nil
+	},
+	DISCOVERY_FILE: func(proxyName string, conf map[string]interface{}) (DiscoveryProvider, error) {
+		return NewFileDiscovery(proxyName, conf)
+	},
+	DISCOVERY_HTTP: func(proxyName string, conf map[string]interface{}) (DiscoveryProvider, error) {
+		return NewHTTPDiscovery(proxyName, conf)
+	},
+}
+
+// default drain timeout of retired pool
+var DrainTimeout = 10 * time.Second
+
+// discovery context, canceled when synapsor stops
+var discoveryCtx, stopDiscovery = context.WithCancel(context.Background())
+
+// register discovery provider
+func RegisterDiscoveryProvider(discoveryType string, factory DiscoveryFactory) {
+	discoveryFactories[strings.ToLower(discoveryType)] = factory
+}
+
+// start endpoint discovery of proxy
+func startDiscovery(proxyName string, discoveryMap map[string]interface{}) {
+	discoveryType := strings.ToLower(configString(discoveryMap, "TYPE", ""))
+	drainTimeout := time.Duration(configInt(discoveryMap, "DRAIN_TIMEOUT", int(DrainTimeout/time.Second))) * time.Second
+	update := func(endpoints []Endpoint) {
+		syncProxyEndpoints(proxyName, endpoints, drainTimeout)
+	}
+
+	factory, ok := discoveryFactories[discoveryType]
+	if !ok {
+		logging.ERROR.Error("unsupported discovery type ", discoveryType, ", proxy ", proxyName)
+		return
+	}
+	provider, err := factory(proxyName, discoveryMap)
+	if err != nil {
+		logging.ERROR.Error("init ", discoveryType, " discovery error, proxy ", proxyName, ": ", err)
+		return
+	}
+	go func() {
+		if err := provider.Run(discoveryCtx, update); err != nil {
+			logging.ERROR.Error(discoveryType, " discovery stopped, proxy ", proxyName, ": ", err)
+		}
+	}()
+}
+
+// sync proxy endpoints, create pools for new endpoints and retire pools of removed endpoints
+func syncProxyEndpoints(proxyName string, endpoints []Endpoint, drainTimeout time.Duration) {
+	connLock.RLock()
+	proxyInitMap, ok := connProxy[proxyName]["proxyInitMap"].(map[string]interface{})
+	current := make(map[string]*Pool)
+	for _, pool := range connPools[proxyName] {
+		current[pool.poolRemoteAddr] = pool
+	}
+	connLock.RUnlock()
+	if !ok {
+		logging.ERROR.Error("sync endpoints error, proxy ", proxyName, " not exist")
+		return
+	}
+
+	wanted := make(map[string]Endpoint)
+	for _, endpoint := range endpoints {
+		wanted[endpoint.Addr] = endpoint
+	}
+	// add new endpoints and update weight/labels of existing endpoints
+	for addr, endpoint := range wanted {
+		if pool, exists := current[addr]; exists {
+			connLock.Lock()
+			pool.weight = endpoint.Weight
+			pool.labels = endpoint.Labels
+			connLock.Unlock()
+			continue
+		}
+		poolInitMap := newPoolInitMap(proxyInitMap, endpoint)
+		poolInitMap["discovered"] = true
+		pool := initGrpcProxyPool(poolInitMap)
+		if pool != nil {
+			logging.Log.Info("discovery add endpoint ", addr, " weight ", strconv.Itoa(int(endpoint.Weight)), " to proxy ", proxyName)
+		}
+	}
+	// retire removed endpoints
+	for addr, pool := range current {
+		if _, exists := wanted[addr]; exists {
+			continue
+		}
+		logging.Log.Info("discovery remove endpoint ", addr, " from proxy ", proxyName)
+		retireGrpcPool(proxyName, pool, drainTimeout)
+	}
+}
+
+// retire grpc pool, stop new requests and close pool after drain timeout
+func retireGrpcPool(proxyName string, pool *Pool, drainTimeout time.Duration) {
+	connLock.Lock()
+	pool.status = false
+	delete(connPools[proxyName], pool.name)
+	connLock.Unlock()
+
+	time.AfterFunc(drainTimeout, func() {
+		pool.Close()
+		logging.Log.Info("drain pool ", pool.name, " of ", pool.poolRemoteAddr, " finish")
+	})
+}
@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"time"
+)
+
+// dns record type
+const (
+	DNS_RECORD_A   = "A"
+	DNS_RECORD_SRV = "SRV"
+)
+
+// DNSDiscovery 定时解析 A/AAAA 或 SRV 记录发现 endpoints
+type DNSDiscovery struct {
+	proxyName     string
+	host          string
+	port          string
+	record        string
+	interval      time.Duration
+	timeout       time.Duration
+	defaultWeight int32
+	resolver      *net.Resolver
+}
+
+// new dns discovery
+func NewDNSDiscovery(proxyName string, conf map[string]interface{}) *DNSDiscovery {
+	d := &DNSDiscovery{
+		proxyName:     proxyName,
+		host:          configString(conf, "HOST", ""),
+		port:          configString(conf, "PORT", ""),
+		record:        strings.ToUpper(configString(conf, "RECORD", DNS_RECORD_A)),
+		interval:      time.Duration(configInt(conf, "INTERVAL", 30)) * time.Second,
+		timeout:       time.Duration(configInt(conf, "TIMEOUT", 5)) * time.Second,
+		defaultWeight: int32(configInt(conf, "DEFAULT_WEIGHT", 10)),
+		resolver:      net.DefaultResolver,
+	}
+	// custom dns server, host:port
+	if server := configString(conf, "RESOLVER", ""); server != "" {
+		d.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return d
+}
+
+// resolve records on interval until ctx done
+func (d *DNSDiscovery) Run(ctx context.Context, update func([]Endpoint)) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		resolveCtx, cancel := context.WithTimeout(ctx, d.timeout)
+		endpoints, err := d.Endpoints(resolveCtx)
+		cancel()
+		if err != nil {
+			// keep current endpoints when dns is unavailable
+			logging.ERROR.Error("dns discovery resolve ", d.host, " error, proxy ", d.proxyName, ": ", err)
+		} else {
+			update(endpoints)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolve endpoints
+func (d *DNSDiscovery) Endpoints(ctx context.Context) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	var err error
+	switch d.record {
+	case DNS_RECORD_SRV:
+		endpoints, err = d.resolveSRV(ctx)
+	default:
+		endpoints, err = d.resolveHost(ctx, d.host, d.port, d.defaultWeight)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+	return endpoints, nil
+}
+
+// resolve A/AAAA records of host
+func (d *DNSDiscovery) resolveHost(ctx context.Context, host, port string, weight int32) ([]Endpoint, error) {
+	addrs, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []Endpoint
+	for _, addr := range addrs {
+		endpoints = append(endpoints, Endpoint{
+			Addr:   net.JoinHostPort(addr.IP.String(), port),
+			Weight: weight,
+			Labels: make(map[string]string),
+		})
+	}
+	return endpoints, nil
+}
+
+// resolve SRV records, only the records with lowest priority are used
+func (d *DNSDiscovery) resolveSRV(ctx context.Context) ([]Endpoint, error) {
+	_, srvs, err := d.resolver.LookupSRV(ctx, "", "", d.host)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []Endpoint
+	var lastErr error
+	for _, srv := range srvs {
+		if srv.Priority != srvs[0].Priority {
+			continue
+		}
+		weight := int32(srv.Weight)
+		if weight <= 0 {
+			weight = d.defaultWeight
+		}
+		hostEndpoints, err := d.resolveHost(ctx, strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)), weight)
+		if err != nil {
+			logging.ERROR.Error("dns discovery resolve srv target ", srv.Target, " error: ", err)
+			lastErr = err
+			continue
+		}
+		endpoints = append(endpoints, hostEndpoints...)
+	}
+	// all targets failed
+	if len(endpoints) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return endpoints, nil
+}
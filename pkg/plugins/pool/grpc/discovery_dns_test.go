@@ -0,0 +1,229 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub 本地 DNS 服务, 按名称返回 A/AAAA/SRV 记录
+type dnsStub struct {
+	lock sync.Mutex
+	ips  map[string][]net.IP
+	srvs map[string][]net.SRV
+	fail bool // 返回 SERVFAIL
+	addr string
+}
+
+// start stub dns server on local udp port
+func startDNSStub(t *testing.T) *dnsStub {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	stub := &dnsStub{
+		ips:  make(map[string][]net.IP),
+		srvs: make(map[string][]net.SRV),
+		addr: conn.LocalAddr().String(),
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp, err := stub.answer(buf[:n]); err == nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return stub
+}
+
+func (s *dnsStub) set(update func(s *dnsStub)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	update(s)
+}
+
+// answer the query
+func (s *dnsStub) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	header.Response, header.Authoritative = true, true
+	ips, hasIPs := s.ips[name]
+	srvs, hasSRVs := s.srvs[name]
+	switch {
+	case s.fail:
+		header.RCode = dnsmessage.RCodeServerFailure
+	case !hasIPs && !hasSRVs:
+		header.RCode = dnsmessage.RCodeNameError
+	}
+	builder := dnsmessage.NewBuilder(nil, header)
+	builder.EnableCompression()
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAnswers()
+	resource := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 1}
+	if header.RCode == dnsmessage.RCodeSuccess {
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil && question.Type == dnsmessage.TypeA {
+				a := dnsmessage.AResource{}
+				copy(a.A[:], ip4)
+				builder.AResource(resource, a)
+			} else if ip4 == nil && question.Type == dnsmessage.TypeAAAA {
+				aaaa := dnsmessage.AAAAResource{}
+				copy(aaaa.AAAA[:], ip.To16())
+				builder.AAAAResource(resource, aaaa)
+			}
+		}
+		if question.Type == dnsmessage.TypeSRV {
+			for _, srv := range srvs {
+				builder.SRVResource(resource, dnsmessage.SRVResource{
+					Priority: srv.Priority,
+					Weight:   srv.Weight,
+					Port:     srv.Port,
+					Target:   dnsmessage.MustNewName(srv.Target + "."),
+				})
+			}
+		}
+	}
+	return builder.Finish()
+}
+
+func TestDNSDiscoveryA(t *testing.T) {
+	stub := startDNSStub(t)
+	stub.set(func(s *dnsStub) {
+		s.ips["hello.synapsor.test"] = []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")}
+	})
+	d := NewDNSDiscovery("hello", map[string]interface{}{
+		"HOST":           "hello.synapsor.test",
+		"PORT":           "50051",
+		"RESOLVER":       stub.addr,
+		"DEFAULT_WEIGHT": 7,
+	})
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Endpoint{
+		{Addr: "10.0.0.1:50051", Weight: 7, Labels: map[string]string{}},
+		{Addr: "10.0.0.2:50051", Weight: 7, Labels: map[string]string{}},
+		{Addr: "[fd00::1]:50051", Weight: 7, Labels: map[string]string{}},
+	}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("endpoints %v, want %v", endpoints, want)
+	}
+
+	d.host = "missing.synapsor.test"
+	if _, err := d.Endpoints(context.Background()); err == nil {
+		t.Error("resolve missing host, want error")
+	}
+}
+
+func TestDNSDiscoverySRV(t *testing.T) {
+	stub := startDNSStub(t)
+	stub.set(func(s *dnsStub) {
+		s.srvs["_grpc._tcp.hello.synapsor.test"] = []net.SRV{
+			{Target: "a.synapsor.test", Port: 9000, Priority: 10, Weight: 5},
+			{Target: "b.synapsor.test", Port: 9001, Priority: 10, Weight: 0},
+			// only records with lowest priority are used
+			{Target: "c.synapsor.test", Port: 9002, Priority: 20, Weight: 5},
+		}
+		s.ips["a.synapsor.test"] = []net.IP{net.ParseIP("10.0.1.1")}
+		s.ips["b.synapsor.test"] = []net.IP{net.ParseIP("10.0.1.2")}
+		s.ips["c.synapsor.test"] = []net.IP{net.ParseIP("10.0.1.3")}
+	})
+	d := NewDNSDiscovery("hello", map[string]interface{}{
+		"HOST":     "_grpc._tcp.hello.synapsor.test",
+		"RECORD":   "srv",
+		"RESOLVER": stub.addr,
+	})
+	endpoints, err := d.Endpoints(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Endpoint{
+		{Addr: "10.0.1.1:9000", Weight: 5, Labels: map[string]string{}},
+		{Addr: "10.0.1.2:9001", Weight: 10, Labels: map[string]string{}},
+	}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("endpoints %v, want %v", endpoints, want)
+	}
+}
+
+func TestDNSDiscoveryRun(t *testing.T) {
+	stub := startDNSStub(t)
+	stub.set(func(s *dnsStub) {
+		s.ips["hello.synapsor.test"] = []net.IP{net.ParseIP("10.0.0.1")}
+	})
+	d := NewDNSDiscovery("hello", map[string]interface{}{
+		"HOST":     "hello.synapsor.test",
+		"PORT":     "50051",
+		"RESOLVER": stub.addr,
+		"INTERVAL": 1,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan []Endpoint, 10)
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx, func(endpoints []Endpoint) { updates <- endpoints }) }()
+
+	next := func() []Endpoint {
+		select {
+		case endpoints := <-updates:
+			return endpoints
+		case <-time.After(5 * time.Second):
+			t.Fatal("no endpoints update")
+			return nil
+		}
+	}
+	if endpoints := next(); len(endpoints) != 1 || endpoints[0].Addr != "10.0.0.1:50051" {
+		t.Errorf("endpoints %v", endpoints)
+	}
+
+	// re-resolved on interval
+	stub.set(func(s *dnsStub) {
+		s.ips["hello.synapsor.test"] = []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.3")}
+	})
+	if endpoints := next(); len(endpoints) != 2 || endpoints[1].Addr != "10.0.0.3:50051" {
+		t.Errorf("endpoints %v", endpoints)
+	}
+
+	// current endpoints are kept when dns fails
+	stub.set(func(s *dnsStub) { s.fail = true })
+	select {
+	case endpoints := <-updates:
+		t.Errorf("update %v on dns failure", endpoints)
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("run error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("run not stopped")
+	}
+}
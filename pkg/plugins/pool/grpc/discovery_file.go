@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// FileDiscovery 监听 JSON/YAML 文件获取 endpoints
+type FileDiscovery struct {
+	proxyName     string
+	path          string
+	defaultWeight int32
+}
+
+// new file discovery
+func NewFileDiscovery(proxyName string, conf map[string]interface{}) (*FileDiscovery, error) {
+	path := configString(conf, "PATH", "")
+	if path == "" {
+		return nil, errors.New("file discovery PATH is empty")
+	}
+	return &FileDiscovery{
+		proxyName:     proxyName,
+		path:          filepath.Clean(path),
+		defaultWeight: int32(configInt(conf, "DEFAULT_WEIGHT", 10)),
+	}, nil
+}
+
+// watch endpoints file until ctx done
+func (d *FileDiscovery) Run(ctx context.Context, update func([]Endpoint)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// watch the dir, the file may be replaced by rename (configmap, editor)
+	if err := watcher.Add(filepath.Dir(d.path)); err != nil {
+		return err
+	}
+	d.load(update)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != d.path && !strings.Contains(event.Name, "..data") {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			// wait for writing finish
+			time.Sleep(100 * time.Millisecond)
+			d.load(update)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logging.ERROR.Error("file discovery watch ", d.path, " error: ", err)
+		}
+	}
+}
+
+// load endpoints file
+func (d *FileDiscovery) load(update func([]Endpoint)) {
+	endpoints, err := d.Endpoints()
+	if err != nil {
+		// keep current endpoints when file is invalid
+		logging.ERROR.Error("file discovery load ", d.path, " error, proxy ", d.proxyName, ": ", err)
+		return
+	}
+	update(endpoints)
+}
+
+// read endpoints from file
+func (d *FileDiscovery) Endpoints() ([]Endpoint, error) {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	format := "json"
+	if ext := strings.ToLower(filepath.Ext(d.path)); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+	return parseEndpointList(data, format, d.defaultWeight)
+}
+
+// parse endpoint list of json or yaml
+func parseEndpointList(data []byte, format string, defaultWeight int32) ([]Endpoint, error) {
+	var list EndpointList
+	var err error
+	if format == "yaml" {
+		err = yaml.Unmarshal(data, &list)
+	} else {
+		err = json.Unmarshal(data, &list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]Endpoint, 0, len(list.Endpoints))
+	for _, endpoint := range list.Endpoints {
+		if endpoint.Addr == "" {
+			return nil, ErrEndpointInvalid
+		}
+		if endpoint.Weight <= 0 {
+			endpoint.Weight = defaultWeight
+		}
+		if endpoint.Labels == nil {
+			endpoint.Labels = make(map[string]string)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
@@ -0,0 +1,106 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"time"
+)
+
+// HTTPDiscovery 轮询 HTTP 接口获取 endpoints, 支持 ETag
+type HTTPDiscovery struct {
+	proxyName     string
+	url           string
+	interval      time.Duration
+	defaultWeight int32
+	headers       map[string]string
+	client        *http.Client
+	etag          string
+}
+
+// new http discovery
+func NewHTTPDiscovery(proxyName string, conf map[string]interface{}) (*HTTPDiscovery, error) {
+	url := configString(conf, "URL", "")
+	if url == "" {
+		return nil, errors.New("http discovery URL is empty")
+	}
+	return &HTTPDiscovery{
+		proxyName:     proxyName,
+		url:           url,
+		interval:      time.Duration(configInt(conf, "INTERVAL", 30)) * time.Second,
+		defaultWeight: int32(configInt(conf, "DEFAULT_WEIGHT", 10)),
+		headers:       configStringMap(conf, "HEADERS"),
+		client: &http.Client{
+			Timeout: time.Duration(configInt(conf, "TIMEOUT", 5)) * time.Second,
+		},
+	}, nil
+}
+
+// poll endpoints on interval until ctx done
+func (d *HTTPDiscovery) Run(ctx context.Context, update func([]Endpoint)) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		endpoints, changed, err := d.poll(ctx)
+		if err != nil {
+			// keep current endpoints when the url is unavailable
+			logging.ERROR.Error("http discovery poll ", d.url, " error, proxy ", d.proxyName, ": ", err)
+		} else if changed {
+			update(endpoints)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll endpoints, changed is false when the server returns 304
+func (d *HTTPDiscovery) poll(ctx context.Context) ([]Endpoint, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range d.headers {
+		req.Header.Set(k, v)
+	}
+	if d.etag != "" {
+		req.Header.Set("If-None-Match", d.etag)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, false, nil
+	case http.StatusOK:
+	default:
+		return nil, false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	format := "json"
+	if strings.Contains(resp.Header.Get("Content-Type"), "yaml") {
+		format = "yaml"
+	}
+	endpoints, err := parseEndpointList(body, format, d.defaultWeight)
+	if err != nil {
+		return nil, false, err
+	}
+	d.etag = resp.Header.Get("ETag")
+	return endpoints, true, nil
+}
@@ -0,0 +1,286 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// default weight annotation of pod
+const DEFAULT_WEIGHT_ANNOTATION = "synapsor.io/weight"
+
+// KubernetesDiscovery 通过 EndpointSlice 发现 Kubernetes Service 的 endpoints
+type KubernetesDiscovery struct {
+	proxyName        string
+	client           kubernetes.Interface
+	namespace        string
+	service          string
+	port             string // port name or number
+	weightAnnotation string
+	defaultWeight    int32
+	resync           time.Duration
+	sliceLister      discoverylisters.EndpointSliceLister
+	podLister        listersv1.PodLister
+	lock             sync.Mutex
+	synced           int32
+	podLock          sync.Mutex         // 串行切换 pod informer
+	podSelector      labels.Set         // 当前 watch 的 pod selector
+	podWatched       bool               // 是否已按 Service selector 初始化
+	stopPods         context.CancelFunc // 停止当前 pod informer
+}
+
+// new kubernetes client, use in-cluster config if kubeconfig is empty
+func newKubernetesClient(kubeconfig string) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// new kubernetes discovery
+func NewKubernetesDiscovery(proxyName string, conf map[string]interface{}, client kubernetes.Interface) *KubernetesDiscovery {
+	return &KubernetesDiscovery{
+		proxyName:        proxyName,
+		client:           client,
+		namespace:        configString(conf, "NAMESPACE", metav1.NamespaceDefault),
+		service:          configString(conf, "SERVICE", ""),
+		port:             configString(conf, "PORT", ""),
+		weightAnnotation: configString(conf, "WEIGHT_ANNOTATION", DEFAULT_WEIGHT_ANNOTATION),
+		defaultWeight:    int32(configInt(conf, "DEFAULT_WEIGHT", 10)),
+		resync:           time.Duration(configInt(conf, "RESYNC", 60)) * time.Second,
+	}
+}
+
+// watch endpoint slices of the service until ctx done
+func (d *KubernetesDiscovery) Run(ctx context.Context, update func([]Endpoint)) error {
+	sliceFactory := informers.NewSharedInformerFactoryWithOptions(d.client, d.resync,
+		informers.WithNamespace(d.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = discoveryv1.LabelServiceName + "=" + d.service
+		}))
+	serviceFactory := informers.NewSharedInformerFactoryWithOptions(d.client, d.resync,
+		informers.WithNamespace(d.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", d.service).String()
+		}))
+
+	sliceInformer := sliceFactory.Discovery().V1().EndpointSlices()
+	serviceInformer := serviceFactory.Core().V1().Services()
+	d.sliceLister = sliceInformer.Lister()
+
+	sliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { d.sync(update) },
+		UpdateFunc: func(oldObj, newObj interface{}) { d.sync(update) },
+		DeleteFunc: func(obj interface{}) { d.sync(update) },
+	})
+	// only pods selected by the service are watched
+	watchService := func(obj interface{}) {
+		if service, ok := obj.(*corev1.Service); ok && service.Name == d.service {
+			d.watchPods(ctx, service.Spec.Selector, update)
+		}
+	}
+	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    watchService,
+		UpdateFunc: func(oldObj, newObj interface{}) { watchService(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			if service, ok := obj.(*corev1.Service); !ok || service.Name == d.service {
+				d.watchPods(ctx, nil, update)
+			}
+		},
+	})
+
+	sliceFactory.Start(ctx.Done())
+	serviceFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), sliceInformer.Informer().HasSynced, serviceInformer.Informer().HasSynced) {
+		return ctx.Err()
+	}
+	// weights of the first sync need pods of the service
+	var selector map[string]string
+	if service, err := serviceInformer.Lister().Services(d.namespace).Get(d.service); err == nil {
+		selector = service.Spec.Selector
+	}
+	d.watchPods(ctx, selector, update)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	atomic.StoreInt32(&d.synced, 1)
+	d.sync(update)
+	logging.Log.Info("kubernetes discovery ", d.namespace, "/", d.service, " of proxy ", d.proxyName, " synced")
+
+	<-ctx.Done()
+	return nil
+}
+
+// watch pods matching selector of the service, the informer is restarted when the selector changes,
+// no pod is watched for services without selector
+func (d *KubernetesDiscovery) watchPods(ctx context.Context, selector map[string]string, update func([]Endpoint)) {
+	d.podLock.Lock()
+	defer d.podLock.Unlock()
+	if d.podWatched && labels.Equals(d.podSelector, selector) {
+		return
+	}
+	if d.stopPods != nil {
+		d.stopPods()
+		d.stopPods = nil
+	}
+	d.podWatched = true
+	d.podSelector = labels.Set(selector)
+
+	var podLister listersv1.PodLister
+	if len(selector) > 0 {
+		podCtx, cancel := context.WithCancel(ctx)
+		podFactory := informers.NewSharedInformerFactoryWithOptions(d.client, d.resync,
+			informers.WithNamespace(d.namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = labels.SelectorFromSet(selector).String()
+			}))
+		podInformer := podFactory.Core().V1().Pods()
+		// changes of labels and weight annotation change endpoints, status updates are ignored
+		podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) { d.sync(update) },
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldPod, newPod := oldObj.(*corev1.Pod), newObj.(*corev1.Pod)
+				if !labels.Equals(oldPod.Labels, newPod.Labels) ||
+					oldPod.Annotations[d.weightAnnotation] != newPod.Annotations[d.weightAnnotation] {
+					d.sync(update)
+				}
+			},
+			DeleteFunc: func(obj interface{}) { d.sync(update) },
+		})
+		podFactory.Start(podCtx.Done())
+		if !cache.WaitForCacheSync(podCtx.Done(), podInformer.Informer().HasSynced) {
+			cancel()
+			return
+		}
+		d.stopPods = cancel
+		podLister = podInformer.Lister()
+	}
+
+	d.lock.Lock()
+	d.podLister = podLister
+	d.lock.Unlock()
+	d.sync(update)
+}
+
+// sync endpoints after caches synced
+func (d *KubernetesDiscovery) sync(update func([]Endpoint)) {
+	if atomic.LoadInt32(&d.synced) != 1 {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	endpoints, err := d.Endpoints()
+	if err != nil {
+		logging.ERROR.Error("kubernetes discovery list endpoints error: ", err)
+		return
+	}
+	update(endpoints)
+}
+
+// get ready endpoints of the service
+func (d *KubernetesDiscovery) Endpoints() ([]Endpoint, error) {
+	slices, err := d.sliceLister.EndpointSlices(d.namespace).List(labels.SelectorFromSet(labels.Set{
+		discoveryv1.LabelServiceName: d.service,
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	endpointMap := make(map[string]Endpoint)
+	for _, slice := range slices {
+		port, ok := d.slicePort(slice)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			weight, podLabels := d.podWeight(ep.TargetRef)
+			for _, address := range ep.Addresses {
+				addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
+				endpointMap[addr] = Endpoint{
+					Addr:   addr,
+					Weight: weight,
+					Labels: podLabels,
+				}
+			}
+		}
+	}
+
+	endpoints := make([]Endpoint, 0, len(endpointMap))
+	for _, endpoint := range endpointMap {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Addr < endpoints[j].Addr
+	})
+	return endpoints, nil
+}
+
+// get endpoint slice port by name or number
+func (d *KubernetesDiscovery) slicePort(slice *discoveryv1.EndpointSlice) (int32, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if d.port == "" || (p.Name != nil && *p.Name == d.port) || strconv.Itoa(int(*p.Port)) == d.port {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// get weight from pod annotation and labels of pod
+func (d *KubernetesDiscovery) podWeight(ref *corev1.ObjectReference) (int32, map[string]string) {
+	podLabels := make(map[string]string)
+	if ref == nil || ref.Kind != "Pod" {
+		return d.defaultWeight, podLabels
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = d.namespace
+	}
+	if d.podLister == nil {
+		return d.defaultWeight, podLabels
+	}
+	pod, err := d.podLister.Pods(namespace).Get(ref.Name)
+	if err != nil {
+		return d.defaultWeight, podLabels
+	}
+	for k, v := range pod.Labels {
+		podLabels[k] = v
+	}
+	if weightStr, ok := pod.Annotations[d.weightAnnotation]; ok {
+		if weight, err := strconv.Atoi(weightStr); err == nil && weight > 0 {
+			return int32(weight), podLabels
+		}
+	}
+	return d.defaultWeight, podLabels
+}
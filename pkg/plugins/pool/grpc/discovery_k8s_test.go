@@ -0,0 +1,186 @@
+package grpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func k8sPod(name string, podLabels map[string]string, weight string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: podLabels}}
+	if weight != "" {
+		pod.Annotations = map[string]string{DEFAULT_WEIGHT_ANNOTATION: weight}
+	}
+	return pod
+}
+
+func k8sEndpointSlice(name string, ports map[string]int32, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "hello"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+	}
+	for portName, port := range ports {
+		portName, port := portName, port
+		slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{Name: &portName, Port: &port})
+	}
+	return slice
+}
+
+func k8sEndpoint(address string, ready bool, podName string) discoveryv1.Endpoint {
+	endpoint := discoveryv1.Endpoint{
+		Addresses:  []string{address},
+		Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+	}
+	if podName != "" {
+		endpoint.TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: podName}
+	}
+	return endpoint
+}
+
+// run discovery and return the channel of endpoint updates
+func runK8sDiscovery(t *testing.T, d *KubernetesDiscovery) <-chan []Endpoint {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	updates := make(chan []Endpoint, 100)
+	go d.Run(ctx, func(endpoints []Endpoint) { updates <- endpoints })
+	return updates
+}
+
+// wait for an update equal to want, earlier updates are skipped
+func waitK8sEndpoints(t *testing.T, updates <-chan []Endpoint, want []Endpoint) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	var last []Endpoint
+	for {
+		select {
+		case endpoints := <-updates:
+			if reflect.DeepEqual(endpoints, want) {
+				return
+			}
+			last = endpoints
+		case <-timeout:
+			t.Fatalf("endpoints %v, want %v", last, want)
+		}
+	}
+}
+
+func TestNewKubernetesDiscovery(t *testing.T) {
+	d := NewKubernetesDiscovery("hello", map[string]interface{}{
+		"NAMESPACE":      "apps",
+		"SERVICE":        "hello",
+		"PORT":           "grpc",
+		"DEFAULT_WEIGHT": 5,
+		"RESYNC":         "30",
+	}, fake.NewSimpleClientset())
+	if d.namespace != "apps" || d.service != "hello" || d.port != "grpc" {
+		t.Errorf("namespace %q service %q port %q", d.namespace, d.service, d.port)
+	}
+	if d.defaultWeight != 5 || d.resync != 30*time.Second || d.weightAnnotation != DEFAULT_WEIGHT_ANNOTATION {
+		t.Errorf("default weight %d resync %s annotation %q", d.defaultWeight, d.resync, d.weightAnnotation)
+	}
+
+	d = NewKubernetesDiscovery("hello", map[string]interface{}{"SERVICE": "hello"}, fake.NewSimpleClientset())
+	if d.namespace != metav1.NamespaceDefault || d.defaultWeight != 10 || d.resync != time.Minute {
+		t.Errorf("namespace %q default weight %d resync %s", d.namespace, d.defaultWeight, d.resync)
+	}
+}
+
+func TestKubernetesDiscoveryEndpoints(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "hello"}},
+		},
+		k8sPod("hello-1", map[string]string{"app": "hello", "version": "v1"}, "20"),
+		k8sPod("hello-2", map[string]string{"app": "hello", "version": "v2"}, ""),
+		k8sEndpointSlice("hello-abc", map[string]int32{"grpc": 8080, "metrics": 9090},
+			k8sEndpoint("10.0.0.1", true, "hello-1"),
+			k8sEndpoint("10.0.0.2", true, "hello-2"),
+			k8sEndpoint("10.0.0.3", false, "hello-3"),
+		),
+	)
+	d := NewKubernetesDiscovery("hello", map[string]interface{}{"SERVICE": "hello", "PORT": "grpc"}, client)
+	updates := runK8sDiscovery(t, d)
+
+	waitK8sEndpoints(t, updates, []Endpoint{
+		{Addr: "10.0.0.1:8080", Weight: 20, Labels: map[string]string{"app": "hello", "version": "v1"}},
+		{Addr: "10.0.0.2:8080", Weight: 10, Labels: map[string]string{"app": "hello", "version": "v2"}},
+	})
+
+	// weight annotation of pod changes
+	ctx := context.Background()
+	pod := k8sPod("hello-2", map[string]string{"app": "hello", "version": "v2"}, "30")
+	if _, err := client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitK8sEndpoints(t, updates, []Endpoint{
+		{Addr: "10.0.0.1:8080", Weight: 20, Labels: map[string]string{"app": "hello", "version": "v1"}},
+		{Addr: "10.0.0.2:8080", Weight: 30, Labels: map[string]string{"app": "hello", "version": "v2"}},
+	})
+
+	// endpoint becomes not ready
+	slice := k8sEndpointSlice("hello-abc", map[string]int32{"grpc": 8080},
+		k8sEndpoint("10.0.0.1", false, "hello-1"),
+		k8sEndpoint("10.0.0.2", true, "hello-2"),
+	)
+	if _, err := client.DiscoveryV1().EndpointSlices("default").Update(ctx, slice, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitK8sEndpoints(t, updates, []Endpoint{
+		{Addr: "10.0.0.2:8080", Weight: 30, Labels: map[string]string{"app": "hello", "version": "v2"}},
+	})
+
+	if err := client.DiscoveryV1().EndpointSlices("default").Delete(ctx, "hello-abc", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitK8sEndpoints(t, updates, []Endpoint{})
+}
+
+func TestKubernetesDiscoveryPodSelector(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "hello"}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "other"}},
+		},
+		k8sPod("hello-1", map[string]string{"app": "hello"}, "20"),
+		k8sPod("other-1", map[string]string{"app": "other"}, "40"),
+		k8sEndpointSlice("hello-abc", map[string]int32{"grpc": 8080},
+			k8sEndpoint("10.0.0.1", true, "hello-1"),
+			// pod not selected by the service is not watched
+			k8sEndpoint("10.0.0.9", true, "other-1"),
+		),
+	)
+	d := NewKubernetesDiscovery("hello", map[string]interface{}{"SERVICE": "hello"}, client)
+	updates := runK8sDiscovery(t, d)
+
+	waitK8sEndpoints(t, updates, []Endpoint{
+		{Addr: "10.0.0.1:8080", Weight: 20, Labels: map[string]string{"app": "hello"}},
+		{Addr: "10.0.0.9:8080", Weight: 10, Labels: map[string]string{}},
+	})
+
+	// selector of the service removed, no pod is watched
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default"}}
+	if _, err := client.CoreV1().Services("default").Update(context.Background(), service, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitK8sEndpoints(t, updates, []Endpoint{
+		{Addr: "10.0.0.1:8080", Weight: 10, Labels: map[string]string{}},
+		{Addr: "10.0.0.9:8080", Weight: 10, Labels: map[string]string{}},
+	})
+}
@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// endpoint config invalid
+var ErrEndpointInvalid = errors.New("endpoint config invalid")
+
+// Endpoint 负载的后端节点
+type Endpoint struct {
+	Addr   string            `json:"addr" yaml:"addr"`     // 地址 host:port
+	Weight int32             `json:"weight" yaml:"weight"` // 权重
+	Labels map[string]string `json:"labels" yaml:"labels"` // 标签, 用于 subset 路由
+}
+
+// EndpointList file 和 http 服务发现返回的 endpoints 列表
+type EndpointList struct {
+	Endpoints []Endpoint `json:"endpoints" yaml:"endpoints"`
+}
+
+// parse endpoint, format: host:port#weight[#key=value,key=value]
+func parseEndpoint(str string) (Endpoint, error) {
+	var endpoint Endpoint
+	endPointList := strings.Split(str, "#")
+	if len(endPointList) < 2 {
+		return endpoint, ErrEndpointInvalid
+	}
+	weight, err := strconv.Atoi(endPointList[1])
+	if err != nil {
+		return endpoint, ErrEndpointInvalid
+	}
+	endpoint.Addr = endPointList[0]
+	endpoint.Weight = int32(weight)
+	endpoint.Labels = make(map[string]string)
+	if len(endPointList) > 2 {
+		labels, err := parseLabels(endPointList[2])
+		if err != nil {
+			return endpoint, err
+		}
+		endpoint.Labels = labels
+	}
+	return endpoint, nil
+}
+
+// parse labels, format: key=value,key=value
+func parseLabels(str string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(str, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, ErrEndpointInvalid
+		}
+		labels[pair[0]] = pair[1]
+	}
+	return labels, nil
+}
+
+// whether labels contain all subset key/value
+func matchLabels(labels, subset map[string]string) bool {
+	for k, v := range subset {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
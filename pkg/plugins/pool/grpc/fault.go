@@ -0,0 +1,266 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fault injection errors
+var (
+	ErrFaultRuleNotFound     = errors.New("fault rule not found")
+	ErrFaultInjectionRefused = errors.New("fault injection is not allowed in production run mode")
+	ErrFaultRuleInvalid      = errors.New("fault rule config invalid")
+)
+
+// FaultRule 故障注入规则
+type FaultRule struct {
+	Name       string
+	Methods    []string
+	Match      []*MetadataMatch
+	Percentage float64
+	Delay      time.Duration
+	DelayMax   time.Duration
+	Abort      bool
+	AbortCode  codes.Code
+	CutAfter   int
+	enabled    int32
+}
+
+// FaultRuleView 故障注入规则的展示数据
+type FaultRuleView struct {
+	Proxy      string   `json:"proxy"`
+	Name       string   `json:"name"`
+	Enabled    bool     `json:"enabled"`
+	Methods    []string `json:"methods"`
+	Percentage float64  `json:"percentage"`
+	Delay      int64    `json:"delay"`
+	DelayMax   int64    `json:"delayMax"`
+	AbortCode  string   `json:"abortCode"`
+	CutAfter   int      `json:"cutAfter"`
+}
+
+// fault action for one call
+type faultAction struct {
+	rule  *FaultRule
+	delay time.Duration
+}
+
+// parse fault rules from proxy config
+func parseFaultRules(proxyName string, proxyMap map[string]interface{}) ([]*FaultRule, error) {
+	var rules []*FaultRule
+	for _, v := range configList(proxyMap, "FAULT_RULES") {
+		ruleMap, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, ErrFaultRuleInvalid
+		}
+		matches, err := parseMetadataMatches(configList(ruleMap, "MATCH"))
+		if err != nil {
+			return nil, err
+		}
+		// request fields are only decoded for routing
+		for _, m := range matches {
+			if m.Field != "" {
+				return nil, ErrFaultRuleInvalid
+			}
+		}
+		rule := &FaultRule{
+			Name:       configString(ruleMap, "NAME", ""),
+			Match:      matches,
+			Percentage: configFloat(ruleMap, "PERCENTAGE", 100),
+			Delay:      time.Duration(configInt(ruleMap, "DELAY", 0)) * time.Millisecond,
+			DelayMax:   time.Duration(configInt(ruleMap, "DELAY_MAX", 0)) * time.Millisecond,
+			AbortCode:  codes.Unavailable,
+			CutAfter:   configInt(ruleMap, "CUT_AFTER", 0),
+		}
+		methods, ok := configStringList(ruleMap, "METHODS")
+		if !ok {
+			return nil, ErrFaultRuleInvalid
+		}
+		rule.Methods = methods
+		if abortCode := configString(ruleMap, "ABORT_CODE", ""); abortCode != "" {
+			code, err := parseStatusCode(abortCode)
+			// status of OK is not an error, nothing would be injected
+			if err != nil || code == codes.OK {
+				return nil, ErrFaultRuleInvalid
+			}
+			rule.Abort = true
+			rule.AbortCode = code
+		}
+		if configBool(ruleMap, "ENABLED", false) {
+			if !FaultInjectionAllowed() {
+				logging.ERROR.Error("fault rule ", proxyName, "/", rule.Name, " disabled: ", ErrFaultInjectionRefused)
+			} else {
+				rule.enabled = 1
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parse grpc status code, support name (UNAVAILABLE) or number (14)
+func parseStatusCode(str string) (codes.Code, error) {
+	var code codes.Code
+	if _, err := strconv.Atoi(str); err == nil {
+		err = code.UnmarshalJSON([]byte(str))
+		return code, err
+	}
+	err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(str))))
+	return code, err
+}
+
+// whether fault injection is allowed in current run mode
+func FaultInjectionAllowed() bool {
+	runMode := os.Getenv("RUN_MODE")
+	if runMode == "" {
+		runMode = viper.GetString("RUN_MODE")
+	}
+	if strings.ToLower(runMode) != "production" {
+		return true
+	}
+	faultAllowed := os.Getenv("FAULT_INJECTION_ALLOWED")
+	if faultAllowed == "" {
+		return viper.GetBool("FAULT_INJECTION_ALLOWED")
+	}
+	allowed, _ := strconv.ParseBool(faultAllowed)
+	return allowed
+}
+
+// whether rule enabled
+func (rule *FaultRule) Enabled() bool {
+	return atomic.LoadInt32(&rule.enabled) == 1
+}
+
+// match method and metadata
+func (rule *FaultRule) matches(fullMethodName string, md metadata.MD) bool {
+	if len(rule.Methods) > 0 {
+		matched := false
+		for _, m := range rule.Methods {
+			if m == "*" || strings.HasPrefix(fullMethodName, m) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, m := range rule.Match {
+		if !m.Matches(md) {
+			return false
+		}
+	}
+	return rand.Float64()*100 < rule.Percentage
+}
+
+// get fault action of the call, nil if no rule matched
+func matchFaultRule(ctx context.Context, fullMethodName string) *faultAction {
+	md, _ := metadata.FromIncomingContext(ctx)
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok {
+		return nil
+	}
+	connLock.RLock()
+	rules, _ := connProxy[proxyName]["faultRules"].([]*FaultRule)
+	connLock.RUnlock()
+	for _, rule := range rules {
+		if !rule.Enabled() || !rule.matches(fullMethodName, md) {
+			continue
+		}
+		action := &faultAction{rule: rule, delay: rule.Delay}
+		if rule.DelayMax > rule.Delay {
+			action.delay += time.Duration(rand.Int63n(int64(rule.DelayMax - rule.Delay)))
+		}
+		return action
+	}
+	return nil
+}
+
+// apply delay and abort before forwarding
+func (action *faultAction) inject(ctx context.Context) error {
+	if action == nil {
+		return nil
+	}
+	if action.delay > 0 {
+		select {
+		case <-time.After(action.delay):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if action.rule.Abort && action.rule.CutAfter <= 0 {
+		return status.Errorf(action.rule.AbortCode, "fault injected by rule %s", action.rule.Name)
+	}
+	return nil
+}
+
+// whether stream should be cut after sent messages
+func (action *faultAction) cut(sent int) error {
+	if action == nil || action.rule.CutAfter <= 0 || sent < action.rule.CutAfter {
+		return nil
+	}
+	return status.Errorf(action.rule.AbortCode, "stream cut by fault rule %s after %d messages", action.rule.Name, sent)
+}
+
+// enable or disable fault rule at runtime
+func SetFaultRuleEnabled(proxyName, ruleName string, enabled bool) error {
+	if enabled && !FaultInjectionAllowed() {
+		return ErrFaultInjectionRefused
+	}
+	connLock.RLock()
+	rules, _ := connProxy[proxyName]["faultRules"].([]*FaultRule)
+	connLock.RUnlock()
+	for _, rule := range rules {
+		if rule.Name != ruleName {
+			continue
+		}
+		var v int32
+		if enabled {
+			v = 1
+		}
+		atomic.StoreInt32(&rule.enabled, v)
+		logging.Log.Info("fault rule ", proxyName, "/", ruleName, " enabled: ", enabled)
+		return nil
+	}
+	return ErrFaultRuleNotFound
+}
+
+// get fault rules
+func GetFaultRules() []FaultRuleView {
+	connLock.RLock()
+	defer connLock.RUnlock()
+
+	views := []FaultRuleView{}
+	for proxyName, proxy := range connProxy {
+		rules, _ := proxy["faultRules"].([]*FaultRule)
+		for _, rule := range rules {
+			view := FaultRuleView{
+				Proxy:      proxyName,
+				Name:       rule.Name,
+				Enabled:    rule.Enabled(),
+				Methods:    rule.Methods,
+				Percentage: rule.Percentage,
+				Delay:      rule.Delay.Milliseconds(),
+				DelayMax:   rule.DelayMax.Milliseconds(),
+				CutAfter:   rule.CutAfter,
+			}
+			if rule.Abort {
+				view.AbortCode = rule.AbortCode.String()
+			}
+			views = append(views, view)
+		}
+	}
+	return views
+}
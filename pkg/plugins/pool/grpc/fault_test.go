@@ -0,0 +1,34 @@
+package grpc
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestParseFaultRules(t *testing.T) {
+	rules, err := parseFaultRules("fault", map[string]interface{}{
+		"FAULT_RULES": []interface{}{
+			map[string]interface{}{"NAME": "abort", "METHODS": []interface{}{"/hello.Greeter/", 1}, "ABORT_CODE": 14},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].AbortCode != codes.Unavailable || len(rules[0].Methods) != 2 || rules[0].Methods[1] != "1" {
+		t.Errorf("rules %+v", rules)
+	}
+
+	invalid := []map[string]interface{}{
+		{"NAME": "ok", "ABORT_CODE": "OK"},
+		{"NAME": "zero", "ABORT_CODE": 0},
+		{"NAME": "unknown", "ABORT_CODE": "NOT_A_CODE"},
+		{"NAME": "methods", "METHODS": []interface{}{map[string]interface{}{"NAME": "x"}}},
+	}
+	for _, rule := range invalid {
+		_, err := parseFaultRules("fault", map[string]interface{}{"FAULT_RULES": []interface{}{rule}})
+		if err != ErrFaultRuleInvalid {
+			t.Errorf("rule %v error %v, want %v", rule["NAME"], err, ErrFaultRuleInvalid)
+		}
+	}
+}
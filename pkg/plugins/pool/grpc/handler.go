@@ -8,9 +8,11 @@ import (
 	logging "synapsor/pkg/core/log"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
@@ -119,7 +121,7 @@ type handler struct {
 }
 
 // handler func
-func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) error {
+func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) (err error) {
 	// get grpc retry times
 	grpcRetryTimes = os.Getenv("GRPC_RETRY_TIMES")
 	if grpcRetryTimes != "" {
@@ -131,19 +133,89 @@ func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) error
 		grpcRetrySleepTimesInt, _ = strconv.Atoi(grpcRetrySleepTimes)
 	}
 
-	now := time.Now()
-	timeStart := now.UnixMilli()
-
 	fullMethodName, ok := grpc.MethodFromServerStream(serverStream)
 	if !ok {
 		return status.Errorf(codes.Internal, "lowLevelServerStream not exists in context")
 	}
 
-	outgoingCtx, backendConn, conn, err := s.director(serverStream.Context(), fullMethodName)
+	// access log and metrics of the call
+	now := time.Now()
+	md, _ := metadata.FromIncomingContext(serverStream.Context())
+	stats := &callStats{}
+	stats.proxy, _ = resolveProxyName(fullMethodName, md)
+	streamStarted(stats.proxy, fullMethodName)
+	ctx, span := startServerSpan(serverStream.Context(), fullMethodName)
+	err = s.serve(&statsServerStream{serverStream, stats, ctx}, fullMethodName, stats)
+	endServerSpan(span, stats, err)
+	observeCall(fullMethodName, now, stats, err)
+	writeAccessLog(serverStream.Context(), fullMethodName, now, stats, err)
+	return err
+}
+
+// proxy the call to backend
+func (s *handler) serve(serverStream grpc.ServerStream, fullMethodName string, stats *callStats) (err error) {
+	now := time.Now()
+
+	// method policy of proxy services
+	policy, err := matchMethodPolicy(serverStream.Context(), fullMethodName)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := policy.context(serverStream.Context())
+	defer cancel()
+	// message size and count limits
+	ctx, limited := newLimitServerStream(ctx, serverStream, stats.proxy, fullMethodName, policy)
+	serverStream = limited
+	defer func() {
+		err = limited.finish(err)
+	}()
+
+	// fault injection
+	fault := matchFaultRule(ctx, fullMethodName)
+	if err := fault.inject(ctx); err != nil {
+		return err
+	}
+	// route by fields of the first request message
+	ctx, first, err := routeByFields(ctx, serverStream, fullMethodName)
+	if err != nil {
+		return err
+	}
+	// idempotency key, repeats replay the result of the first call
+	idempotent, replayed, err := startIdempotentCall(ctx, serverStream, fullMethodName, policy)
+	if err != nil || replayed {
+		return err
+	}
+	if idempotent != nil {
+		serverStream = idempotent
+		ctx = idempotent.context(ctx)
+		defer func() {
+			idempotent.finish(err)
+		}()
+	}
+	// response cache of unary methods, hits bypass backend
+	call, hit, err := startCachedCall(serverStream, &first, fullMethodName, policy)
+	if err != nil || hit {
+		return err
+	}
+	if call != nil {
+		serverStream = call
+		defer func() {
+			call.finish(err)
+		}()
+	}
+	// coalesce identical in-flight unary calls
+	if coalesced, err := s.coalesce(ctx, serverStream, &first, fullMethodName, policy, stats); coalesced {
+		return err
+	}
+	if policy.retryable() {
+		return s.retryHandler(ctx, serverStream, first, fullMethodName, policy, fault, stats)
+	}
+
+	outgoingCtx, backendConn, conn, err := s.director(ctx, fullMethodName)
 	if err != nil {
 		c := 0
 		for ; c < grpcRetryTimesInt; c++ {
-			outgoingCtx, backendConn, conn, err = s.director(serverStream.Context(), fullMethodName)
+			outgoingCtx, backendConn, conn, err = s.director(ctx, fullMethodName)
 			if err == nil {
 				break
 			}
@@ -155,15 +227,29 @@ func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) error
 	}
 
 	defer conn.Close()
+	stats.selectEndpoint(conn)
+	outgoingCtx, clientSpan := startClientSpan(outgoingCtx, fullMethodName, conn, 0)
+	defer func() {
+		endSpan(clientSpan, err)
+	}()
+
+	// mirror traffic to shadow proxy
+	mirror := newMirrorStream(serverStream.Context(), fullMethodName)
+	defer func() {
+		mirror.closeSend()
+		mirror.recordPrimary(err, time.Since(now))
+	}()
 
 	clientCtx, clientCancel := context.WithCancel(outgoingCtx)
+	defer clientCancel()
+	options := append(streamCompression(serverStream, &first, fullMethodName), limitCallOptions(ctx)...)
 	// TODO(mwitkow): Add a `forwarded` header to metadata, https://en.wikipedia.org/wiki/X-Forwarded-For.
-	clientStream, err := grpc.NewClientStream(clientCtx, clientStreamDescForProxying, backendConn, fullMethodName)
+	clientStream, err := grpc.NewClientStream(clientCtx, clientStreamDescForProxying, backendConn, fullMethodName, options...)
 	if err != nil {
 		c := 0
 		for ; c < grpcRetryTimesInt; c++ {
 			logging.ERROR.Error("-----------------------  create stream error:", err.Error())
-			clientStream, err = grpc.NewClientStream(clientCtx, clientStreamDescForProxying, backendConn, fullMethodName)
+			clientStream, err = grpc.NewClientStream(clientCtx, clientStreamDescForProxying, backendConn, fullMethodName, options...)
 			if err != nil {
 				SleepTime(grpcRetrySleepTimesInt)
 			} else {
@@ -175,9 +261,10 @@ func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) error
 			return err
 		}
 	}
+	idempotentStreamOpened(ctx)
 
-	s2cErrChan := s.forwardServerToClient(serverStream, clientStream)
-	c2sErrChan := s.forwardClientToServer(clientStream, serverStream)
+	s2cErrChan := s.forwardServerToClient(serverStream, first, clientStream, mirror)
+	c2sErrChan := s.forwardClientToServer(clientStream, serverStream, fault)
 	for i := 0; i < 2; i++ {
 		select {
 		case s2cErr := <-s2cErrChan:
@@ -190,9 +277,6 @@ func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) error
 				return status.Errorf(codes.Internal, "failed proxying s2c: %v", s2cErr)
 			}
 		case c2sErr := <-c2sErrChan:
-			endNow := time.Now()
-			timeEnd := endNow.UnixMilli()
-			logging.Log.Info("c 2 s", timeStart, timeEnd, "request time spend: ", timeEnd-timeStart, " average request time:")
 			serverStream.SetTrailer(clientStream.Trailer())
 			if c2sErr != io.EOF {
 				logging.ERROR.Error("-----------------------  create stream S2C:", codes.Internal, " failed proxying s2c: ", c2sErr)
@@ -204,14 +288,132 @@ func (s *handler) handler(srv interface{}, serverStream grpc.ServerStream) error
 	return status.Errorf(codes.Internal, "gRPC proxying should never reach this stage.")
 }
 
+// forward with retry, requests are buffered until client half close and replayed on each attempt
+func (s *handler) retryHandler(ctx context.Context, serverStream grpc.ServerStream, first <-chan recvResult, fullMethodName string, policy *MethodPolicy, fault *faultAction, stats *callStats) (err error) {
+	now := time.Now()
+	mirror := newMirrorStream(serverStream.Context(), fullMethodName)
+	defer func() {
+		mirror.closeSend()
+		mirror.recordPrimary(err, time.Since(now))
+	}()
+	var requests []*frame
+	for {
+		f := &frame{}
+		if err := recvFrame(serverStream, &first, f); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		mirror.send(f)
+		requests = append(requests, f)
+	}
+	mirror.closeSend()
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			stats.retry()
+		}
+		var clientStream grpc.ClientStream
+		var first *frame
+		var conn *Client
+		var clientCancel context.CancelFunc
+		var span trace.Span
+		clientStream, first, conn, clientCancel, span, err = s.attempt(ctx, fullMethodName, requests, attempt)
+		stats.selectEndpoint(conn)
+		if err == nil {
+			defer conn.Close()
+			defer clientCancel()
+			err = s.forwardResponse(serverStream, clientStream, first, fault)
+			endSpan(span, err)
+			return err
+		}
+		if attempt >= policy.Retry || !policy.retryOn(err) {
+			return err
+		}
+		logging.ERROR.Error("retry ", fullMethodName, " attempt ", attempt+1, ": ", err)
+		select {
+		case <-time.After(policy.RetryBackoff * time.Duration(attempt+1)):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// send buffered requests and receive the first response, nil frame if no response message
+func (s *handler) attempt(ctx context.Context, fullMethodName string, requests []*frame, attempt int) (grpc.ClientStream, *frame, *Client, context.CancelFunc, trace.Span, error) {
+	outgoingCtx, backendConn, conn, err := s.director(ctx, fullMethodName)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	outgoingCtx, span := startClientSpan(outgoingCtx, fullMethodName, conn, attempt)
+	clientCtx, clientCancel := context.WithCancel(outgoingCtx)
+	fail := func(err error) (grpc.ClientStream, *frame, *Client, context.CancelFunc, trace.Span, error) {
+		clientCancel()
+		conn.Close()
+		endSpan(span, err)
+		return nil, nil, nil, nil, nil, err
+	}
+	compression, _ := proxyCompression(ctx, fullMethodName)
+	options := append(compression.callOptions(requestSize(requests)), limitCallOptions(ctx)...)
+	clientStream, err := grpc.NewClientStream(clientCtx, clientStreamDescForProxying, backendConn, fullMethodName, options...)
+	if err != nil {
+		return fail(err)
+	}
+	idempotentStreamOpened(ctx)
+	for _, f := range requests {
+		// error of send is returned by recv
+		if err := clientStream.SendMsg(f); err != nil {
+			break
+		}
+	}
+	clientStream.CloseSend()
+	first := &frame{}
+	if err := clientStream.RecvMsg(first); err == io.EOF {
+		first = nil
+	} else if err != nil {
+		return fail(err)
+	}
+	return clientStream, first, conn, clientCancel, span, nil
+}
+
+// forward response of backend to client
+func (s *handler) forwardResponse(dst grpc.ServerStream, src grpc.ClientStream, first *frame, fault *faultAction) error {
+	md, err := src.Header()
+	if err != nil {
+		return err
+	}
+	if err := dst.SendHeader(md); err != nil {
+		return err
+	}
+	for i, f := 0, first; f != nil; i++ {
+		if err := dst.SendMsg(f); err != nil {
+			return err
+		}
+		// fault injection cut stream
+		if err := fault.cut(i + 1); err != nil {
+			return err
+		}
+		f = &frame{}
+		if err := src.RecvMsg(f); err == io.EOF {
+			break
+		} else if err != nil {
+			dst.SetTrailer(src.Trailer())
+			return err
+		}
+	}
+	dst.SetTrailer(src.Trailer())
+	return nil
+}
+
 // forward client to server
-func (s *handler) forwardClientToServer(src grpc.ClientStream, dst grpc.ServerStream) chan error {
+func (s *handler) forwardClientToServer(src grpc.ClientStream, dst grpc.ServerStream, fault *faultAction) chan error {
 	ret := make(chan error, 1)
 	go func() {
 		f := &frame{}
 		for i := 0; ; i++ {
 			if err := src.RecvMsg(f); err != nil {
-				if err == io.EOF {
+				// EOF, or the call is canceled or timed out
+				if err == io.EOF || src.Context().Err() != nil {
 					ret <- err
 					break
 				}
@@ -241,6 +443,11 @@ func (s *handler) forwardClientToServer(src grpc.ClientStream, dst grpc.ServerSt
 				}
 			}
 			if err := dst.SendMsg(f); err != nil {
+				// exceeded message limits are not retried
+				if status.Code(err) == codes.ResourceExhausted {
+					ret <- err
+					break
+				}
 				c := 0
 				for ; c < grpcRetryTimesInt; c++ {
 					err = dst.SendMsg(f)
@@ -255,19 +462,30 @@ func (s *handler) forwardClientToServer(src grpc.ClientStream, dst grpc.ServerSt
 					break
 				}
 			}
+			// fault injection cut stream
+			if err := fault.cut(i + 1); err != nil {
+				ret <- err
+				break
+			}
 		}
 	}()
 	return ret
 }
 
 // forward server to client
-func (s *handler) forwardServerToClient(src grpc.ServerStream, dst grpc.ClientStream) chan error {
+func (s *handler) forwardServerToClient(src grpc.ServerStream, first <-chan recvResult, dst grpc.ClientStream, mirror *mirrorStream) chan error {
 	ret := make(chan error, 1)
 	go func() {
 		f := &frame{}
 		for i := 0; ; i++ {
-			if err := src.RecvMsg(f); err != nil {
+			if err := recvFrame(src, &first, f); err != nil {
 				if err == io.EOF {
+					mirror.closeSend()
+					ret <- err
+					break
+				}
+				// exceeded message limits are not retried
+				if status.Code(err) == codes.ResourceExhausted {
 					ret <- err
 					break
 				}
@@ -285,6 +503,7 @@ func (s *handler) forwardServerToClient(src grpc.ServerStream, dst grpc.ClientSt
 					break
 				}
 			}
+			mirror.send(f)
 			if err := dst.SendMsg(f); err != nil {
 				c := 0
 				for ; c < grpcRetryTimesInt; c++ {
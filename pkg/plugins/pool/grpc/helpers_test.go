@@ -0,0 +1,156 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// add proxy without endpoints, conf is set to the proxy config, e.g. "services",
+// the proxy and its descriptors are removed at the end of test
+func testProxy(t *testing.T, name string, conf map[string]interface{}) {
+	t.Helper()
+	initGrpcProxy(name, map[string]interface{}{"proxyModel": "randomWeight"})
+	connLock.Lock()
+	for k, v := range conf {
+		connProxy[name][k] = v
+	}
+	connLock.Unlock()
+	t.Cleanup(func() {
+		removeProxyDescriptors(name)
+		connLock.Lock()
+		defer connLock.Unlock()
+		delete(connPools, name)
+		delete(connProxy, name)
+	})
+}
+
+// load files as descriptor set of proxy
+func testDescriptors(t *testing.T, name string, files ...protoreflect.FileDescriptor) {
+	t.Helper()
+	if err := initProxyDescriptors(name, map[string]interface{}{"DESCRIPTOR_SET": writeTestDescriptorSet(t, files...)}); err != nil {
+		t.Fatal(err)
+	}
+	descriptors, _ := getProxyDescriptors(name)
+	if err := descriptors.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// write descriptor set of files to a temp file
+func writeTestDescriptorSet(t *testing.T, files ...protoreflect.FileDescriptor) string {
+	t.Helper()
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range files {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+	}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "descriptors.pb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// wait until cond is true, fail after 5 seconds
+func waitFor(t *testing.T, name string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for ", name)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// start health server as backend, returns the address and the metadata of received calls
+func startTestBackend(t *testing.T) (string, func() metadata.MD) {
+	t.Helper()
+	var lock sync.Mutex
+	var received metadata.MD
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		lock.Lock()
+		received = md
+		lock.Unlock()
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String(), func() metadata.MD {
+		lock.Lock()
+		defer lock.Unlock()
+		return received
+	}
+}
+
+// add proxy with a pool of the backend
+func initTestProxy(t *testing.T, proxyName, addr string) {
+	t.Helper()
+	pool := initGrpcProxyPool(map[string]interface{}{
+		"proxyName":           proxyName,
+		"proxyModel":          "randomWeight",
+		"serverHost":          addr,
+		"gatewayProxyPort":    addr,
+		"serviceCode":         proxyName + "-pool",
+		"proxyWeight":         "10",
+		"connNum":             2,
+		"poolModel":           MULTIPLEX_MODE,
+		"grpcRequestReusable": true,
+		"requestIdleTime":     60,
+		"requestMaxLife":      60,
+		"requestTimeout":      5,
+		"poolEnabled":         true,
+	})
+	if pool == nil {
+		t.Fatal("init pool of ", addr)
+	}
+	t.Cleanup(func() {
+		ReleaseGrpcPool(proxyName, pool.name)
+		connLock.Lock()
+		defer connLock.Unlock()
+		delete(connPools, proxyName)
+		delete(connProxy, proxyName)
+	})
+}
+
+// start proxy server with transparent handler, returns the client connection
+func startTestProxyServer(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	srv := grpc.NewServer(grpc.CustomCodec(Codec()), grpc.UnknownServiceHandler(TransparentHandler(GrpcProxyTransport)))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,132 @@
+package grpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pathSegment 路径模板的一段, literal 为字面量、"*" 或 "**"
+type pathSegment struct {
+	literal  string
+	variable string // 所属变量的字段路径
+}
+
+// pathTemplate google.api.http 路径模板, 例如 /v1/{name=messages/*}:cancel
+type pathTemplate struct {
+	segments []pathSegment
+	verb     string
+}
+
+// parse path template
+func parsePathTemplate(tpl string) (*pathTemplate, error) {
+	if !strings.HasPrefix(tpl, "/") {
+		return nil, fmt.Errorf("invalid path template %s", tpl)
+	}
+	t := &pathTemplate{}
+	// split segments and verb outside of braces
+	var parts []string
+	depth, start := 0, 1
+	for i := 1; i < len(tpl); i++ {
+		switch tpl[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				parts = append(parts, tpl[start:i])
+				start = i + 1
+			}
+		case ':':
+			if depth == 0 {
+				t.verb = tpl[i+1:]
+				parts = append(parts, tpl[start:i])
+				start = -1
+			}
+		}
+		if depth < 0 || start < 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid path template %s", tpl)
+	}
+	if start > 0 {
+		parts = append(parts, tpl[start:])
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid path template %s", tpl)
+		}
+		if !strings.HasPrefix(part, "{") {
+			t.segments = append(t.segments, pathSegment{literal: part})
+			continue
+		}
+		variable := strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
+		pattern := "*"
+		if i := strings.Index(variable, "="); i >= 0 {
+			variable, pattern = variable[:i], variable[i+1:]
+		}
+		for _, literal := range strings.Split(pattern, "/") {
+			t.segments = append(t.segments, pathSegment{literal: literal, variable: variable})
+		}
+	}
+	return t, nil
+}
+
+// count of literal segments, the more literal template is the more specific
+func (t *pathTemplate) literals() int {
+	n := 0
+	for _, segment := range t.segments {
+		if segment.literal != "*" && segment.literal != "**" {
+			n++
+		}
+	}
+	return n
+}
+
+// match request path, return values of variables
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	values := make(map[string][]string)
+	i := 0
+	for j, segment := range t.segments {
+		if segment.literal == "**" {
+			// match all segments except the segments after **
+			n := len(parts) - i - (len(t.segments) - j - 1)
+			if n < 0 {
+				return nil, false
+			}
+			if segment.variable != "" {
+				values[segment.variable] = append(values[segment.variable], parts[i:i+n]...)
+			}
+			i += n
+			continue
+		}
+		if i >= len(parts) || parts[i] == "" {
+			return nil, false
+		}
+		if segment.literal != "*" && segment.literal != parts[i] {
+			return nil, false
+		}
+		if segment.variable != "" {
+			values[segment.variable] = append(values[segment.variable], parts[i])
+		}
+		i++
+	}
+	if i != len(parts) {
+		return nil, false
+	}
+	vars := make(map[string]string)
+	for variable, v := range values {
+		vars[variable] = strings.Join(v, "/")
+	}
+	return vars, true
+}
@@ -0,0 +1,200 @@
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	logging "synapsor/pkg/core/log"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// concurrent duplicate action
+const (
+	IDEMPOTENCY_WAIT   = "WAIT"
+	IDEMPOTENCY_REJECT = "REJECT"
+)
+
+// idempotency record state
+const (
+	IDEMPOTENCY_PENDING = "pending"
+	IDEMPOTENCY_DONE    = "done"
+)
+
+// response header of replayed call
+const IDEMPOTENCY_REPLAY_HEADER = "x-synapsor-idempotent-replay"
+
+// interval of checking the first call when waiting
+var IdempotencyPollInterval = 100 * time.Millisecond
+
+// set the result or release the key if still owned by the call
+var idempotencyFinishScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	if ARGV[2] == "" then
+		return redis.call("DEL", KEYS[1])
+	end
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return 0`)
+
+// MethodIdempotency 方法的幂等键去重, 第一次调用的结果保存在 redis
+type MethodIdempotency struct {
+	Metadata   string
+	TTL        time.Duration
+	PendingTTL time.Duration // 第一次调用进行中的记录的过期时间
+	Concurrent string        // 第一次调用进行中时的重复调用: WAIT 等待结果, REJECT 返回 ABORTED
+}
+
+// stored result of idempotent call
+type idempotencyRecord struct {
+	State    string      `json:"state"`
+	Owner    string      `json:"owner,omitempty"`
+	Status   []byte      `json:"status,omitempty"`
+	Header   metadata.MD `json:"header,omitempty"`
+	Payloads [][]byte    `json:"payloads,omitempty"`
+	Trailer  metadata.MD `json:"trailer,omitempty"`
+}
+
+// recording stream of the first call of an idempotency key
+type idempotentCall struct {
+	*responseRecorder
+	key     string
+	pending string
+	ttl     time.Duration
+	opened  int32 // 后端 stream 已创建, 请求可能已到达后端
+}
+
+// context key of the idempotent call
+type idempotentCallKey struct{}
+
+// context of the call carrying the idempotent call
+func (call *idempotentCall) context(ctx context.Context) context.Context {
+	if call == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotentCallKey{}, call)
+}
+
+// mark the backend stream of the call opened, the key is not released after that
+func idempotentStreamOpened(ctx context.Context) {
+	if call, _ := ctx.Value(idempotentCallKey{}).(*idempotentCall); call != nil {
+		atomic.StoreInt32(&call.opened, 1)
+	}
+}
+
+// check idempotency key of the call, the stored result is replayed for a repeat,
+// otherwise returns the recording stream of the first call
+func startIdempotentCall(ctx context.Context, serverStream grpc.ServerStream, fullMethodName string, policy *MethodPolicy) (*idempotentCall, bool, error) {
+	if policy == nil || policy.Idempotency == nil || cacheRedis == nil {
+		return nil, false, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(policy.Idempotency.Metadata)
+	if len(keys) == 0 || keys[0] == "" {
+		return nil, false, nil
+	}
+	proxyName, _ := resolveProxyName(fullMethodName, md)
+	key := "synapsor:idempotency:" + proxyName + ":" + fullMethodName + ":" + keys[0]
+
+	owner := make([]byte, 8)
+	rand.Read(owner)
+	pending, _ := json.Marshal(&idempotencyRecord{State: IDEMPOTENCY_PENDING, Owner: hex.EncodeToString(owner)})
+	for {
+		acquired, err := cacheRedis.SetNX(key, pending, policy.Idempotency.PendingTTL).Result()
+		if err != nil {
+			logging.ERROR.Error("idempotency key of ", fullMethodName, ": ", err)
+			return nil, false, nil
+		}
+		if acquired {
+			return &idempotentCall{
+				responseRecorder: newResponseRecorder(serverStream, IDEMPOTENCY_REPLAY_HEADER, "false"),
+				key:              key,
+				pending:          string(pending),
+				ttl:              policy.Idempotency.TTL,
+			}, false, nil
+		}
+		data, err := cacheRedis.Get(key).Bytes()
+		if err == redis.Nil {
+			// the first call released the key
+			continue
+		} else if err != nil {
+			logging.ERROR.Error("idempotency key of ", fullMethodName, ": ", err)
+			return nil, false, nil
+		}
+		record := &idempotencyRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, true, status.Errorf(codes.Internal, "invalid idempotency record: %v", err)
+		}
+		if record.State == IDEMPOTENCY_DONE {
+			return nil, true, record.replay(serverStream)
+		}
+		if policy.Idempotency.Concurrent == IDEMPOTENCY_REJECT {
+			return nil, true, status.Errorf(codes.Aborted, "call with the same %s is in progress", policy.Idempotency.Metadata)
+		}
+		select {
+		case <-time.After(IdempotencyPollInterval):
+		case <-ctx.Done():
+			return nil, true, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// send stored result to client
+func (record *idempotencyRecord) replay(serverStream grpc.ServerStream) error {
+	header := record.Header.Copy()
+	header.Set(IDEMPOTENCY_REPLAY_HEADER, "true")
+	if err := serverStream.SendHeader(header); err != nil {
+		return err
+	}
+	for _, payload := range record.Payloads {
+		if err := serverStream.SendMsg(&frame{payload: payload}); err != nil {
+			return err
+		}
+	}
+	serverStream.SetTrailer(record.Trailer)
+	st := &spb.Status{}
+	if err := proto.Unmarshal(record.Status, st); err != nil {
+		return status.Errorf(codes.Internal, "invalid idempotency record: %v", err)
+	}
+	return status.FromProto(st).Err()
+}
+
+// store result of the first call, the key is released if no backend stream was opened,
+// the pending record is kept until PENDING_TTL if the result of backend is unknown
+func (call *idempotentCall) finish(err error) {
+	if err != nil && atomic.LoadInt32(&call.opened) == 0 {
+		// acquire, dial or pool errors, the request never reached backend
+		call.store("")
+		return
+	}
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Unavailable:
+		return
+	}
+	st, _ := proto.Marshal(status.Convert(err).Proto())
+	data, _ := json.Marshal(&idempotencyRecord{
+		State:    IDEMPOTENCY_DONE,
+		Status:   st,
+		Header:   call.header,
+		Payloads: call.payloads,
+		Trailer:  call.trailer,
+	})
+	call.store(string(data))
+}
+
+// set the record of the key if still owned by the call, empty value releases the key
+func (call *idempotentCall) store(value string) {
+	ttl := int64(call.ttl / time.Millisecond)
+	if err := idempotencyFinishScript.Run(cacheRedis, []string{call.key}, call.pending, value, ttl).Err(); err != nil && err != redis.Nil {
+		logging.ERROR.Error("store idempotency result: ", err)
+	}
+}
@@ -0,0 +1,223 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// limit label of exceeded metric
+const (
+	LIMIT_REQUEST_SIZE  = "request_size"
+	LIMIT_RESPONSE_SIZE = "response_size"
+	LIMIT_MESSAGES      = "messages"
+	LIMIT_STREAM_BYTES  = "stream_bytes"
+)
+
+// default max request size of grpc server
+const defaultServerMaxRecvSize = 4 << 20
+
+// message of grpc size check, the response of backend over MaxCallRecvMsgSize
+const errMessageTooLarge = "received message larger than max"
+
+// context key of message limits
+type messageLimitsKey struct{}
+
+// MessageLimits 调用的消息大小和数量限制, 0 为不限制
+type MessageLimits struct {
+	MaxRequestSize  int   // 单个请求消息字节数, 0 为 grpc server 默认的 4MB
+	MaxResponseSize int   // 单个响应消息字节数
+	MaxMessages     int64 // 调用两个方向的消息总数
+	MaxStreamBytes  int64 // 调用两个方向的消息总字节数
+}
+
+// parse limits, fields not set inherit from parent
+func parseMessageLimits(conf map[string]interface{}, parent *MessageLimits) *MessageLimits {
+	limits := &MessageLimits{}
+	if parent != nil {
+		*limits = *parent
+	}
+	limits.MaxRequestSize = configInt(conf, "MAX_REQUEST_SIZE", limits.MaxRequestSize)
+	limits.MaxResponseSize = configInt(conf, "MAX_RESPONSE_SIZE", limits.MaxResponseSize)
+	limits.MaxMessages = int64(configInt(conf, "MAX_MESSAGES", int(limits.MaxMessages)))
+	limits.MaxStreamBytes = int64(configInt(conf, "MAX_STREAM_BYTES", int(limits.MaxStreamBytes)))
+	return limits
+}
+
+// parse proxy limits, nil if not set
+func parseProxyLimits(proxyMap map[string]interface{}) *MessageLimits {
+	limitsMap := configMap(proxyMap, "LIMITS")
+	if limitsMap == nil {
+		return nil
+	}
+	return parseMessageLimits(limitsMap, nil)
+}
+
+// max request size of proxy grpc server, the largest request size of proxy and methods
+func ServerMaxRecvSize(proxyMap map[string]interface{}) int {
+	size := defaultServerMaxRecvSize
+	if limits := parseProxyLimits(proxyMap); limits != nil && limits.MaxRequestSize > 0 {
+		size = limits.MaxRequestSize
+	}
+	// limits of services and methods
+	for _, v := range configList(configMap(proxyMap, "SERVICES"), "LIST") {
+		serviceMap, _ := v.(map[string]interface{})
+		confs := []map[string]interface{}{serviceMap}
+		for _, m := range configList(serviceMap, "METHODS") {
+			if methodMap, ok := m.(map[string]interface{}); ok {
+				confs = append(confs, methodMap)
+			}
+		}
+		for _, conf := range confs {
+			if maxSize := configInt(configMap(conf, "LIMITS"), "MAX_REQUEST_SIZE", 0); maxSize > size {
+				size = maxSize
+			}
+		}
+	}
+	return size
+}
+
+// limits of the call, fields not set by method policy inherit from proxy,
+// request size defaults to the default max of grpc server which may be raised by limits of other methods
+func callLimits(proxyName string, policy *MethodPolicy) *MessageLimits {
+	connLock.RLock()
+	proxyLimits, _ := connProxy[proxyName]["limits"].(*MessageLimits)
+	connLock.RUnlock()
+	limits := MessageLimits{}
+	if policy != nil && policy.Limits != nil {
+		limits = *policy.Limits
+	}
+	if proxyLimits != nil {
+		if limits.MaxRequestSize == 0 {
+			limits.MaxRequestSize = proxyLimits.MaxRequestSize
+		}
+		if limits.MaxResponseSize == 0 {
+			limits.MaxResponseSize = proxyLimits.MaxResponseSize
+		}
+		if limits.MaxMessages == 0 {
+			limits.MaxMessages = proxyLimits.MaxMessages
+		}
+		if limits.MaxStreamBytes == 0 {
+			limits.MaxStreamBytes = proxyLimits.MaxStreamBytes
+		}
+	}
+	if limits.MaxRequestSize == 0 {
+		limits.MaxRequestSize = defaultServerMaxRecvSize
+	}
+	return &limits
+}
+
+// call options of backend, responses over the limit are rejected before read
+func limitCallOptions(ctx context.Context) []grpc.CallOption {
+	limits, _ := ctx.Value(messageLimitsKey{}).(*MessageLimits)
+	if limits == nil || limits.MaxResponseSize <= 0 {
+		return nil
+	}
+	return []grpc.CallOption{grpc.MaxCallRecvMsgSize(limits.MaxResponseSize)}
+}
+
+// server stream checking message limits
+type limitServerStream struct {
+	grpc.ServerStream
+	limits   *MessageLimits
+	proxy    string
+	method   string
+	messages int64
+	bytes    int64
+	lock     sync.Mutex
+	err      error
+}
+
+// wrap server stream with limits of the call, the limits are put into context for backend calls
+func newLimitServerStream(ctx context.Context, serverStream grpc.ServerStream, proxyName, fullMethodName string, policy *MethodPolicy) (context.Context, *limitServerStream) {
+	limits := callLimits(proxyName, policy)
+	return context.WithValue(ctx, messageLimitsKey{}, limits), &limitServerStream{
+		ServerStream: serverStream,
+		limits:       limits,
+		proxy:        proxyName,
+		method:       fullMethodName,
+	}
+}
+
+// record the first exceeded limit
+func (s *limitServerStream) exceed(limit string, format string, a ...interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err == nil {
+		s.err = status.Errorf(codes.ResourceExhausted, format, a...)
+		observeLimitExceeded(s.proxy, s.method, limit)
+	}
+	return s.err
+}
+
+// check messages and bytes of stream
+func (s *limitServerStream) count(size int) error {
+	messages := atomic.AddInt64(&s.messages, 1)
+	bytes := atomic.AddInt64(&s.bytes, int64(size))
+	if s.limits.MaxMessages > 0 && messages > s.limits.MaxMessages {
+		return s.exceed(LIMIT_MESSAGES, "too many messages in call, max %d", s.limits.MaxMessages)
+	}
+	if s.limits.MaxStreamBytes > 0 && bytes > s.limits.MaxStreamBytes {
+		return s.exceed(LIMIT_STREAM_BYTES, "too many bytes in call, max %d", s.limits.MaxStreamBytes)
+	}
+	return nil
+}
+
+// error of exceeded limit, nil if not exceeded
+func (s *limitServerStream) exceeded() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// receive request and check limits, the stream keeps failing after a limit is exceeded
+func (s *limitServerStream) RecvMsg(m interface{}) error {
+	if err := s.exceeded(); err != nil {
+		return err
+	}
+	err := s.ServerStream.RecvMsg(m)
+	if status.Code(err) == codes.ResourceExhausted {
+		// over max request size of grpc server
+		return s.exceed(LIMIT_REQUEST_SIZE, "%s", status.Convert(err).Message())
+	}
+	f, ok := m.(*frame)
+	if err != nil || !ok {
+		return err
+	}
+	if s.limits.MaxRequestSize > 0 && len(f.payload) > s.limits.MaxRequestSize {
+		return s.exceed(LIMIT_REQUEST_SIZE, "request message larger than max (%d vs. %d)", len(f.payload), s.limits.MaxRequestSize)
+	}
+	return s.count(len(f.payload))
+}
+
+// check limits and send response
+func (s *limitServerStream) SendMsg(m interface{}) error {
+	if err := s.exceeded(); err != nil {
+		return err
+	}
+	if f, ok := m.(*frame); ok {
+		if s.limits.MaxResponseSize > 0 && len(f.payload) > s.limits.MaxResponseSize {
+			return s.exceed(LIMIT_RESPONSE_SIZE, "response message larger than max (%d vs. %d)", len(f.payload), s.limits.MaxResponseSize)
+		}
+		if err := s.count(len(f.payload)); err != nil {
+			return err
+		}
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// status of the call, the exceeded limit or the response rejected by backend call options
+func (s *limitServerStream) finish(err error) error {
+	if exceeded := s.exceeded(); exceeded != nil {
+		return exceeded
+	}
+	if status.Code(err) == codes.ResourceExhausted && strings.Contains(status.Convert(err).Message(), errMessageTooLarge) {
+		return s.exceed(LIMIT_RESPONSE_SIZE, "%s", status.Convert(err).Message())
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package grpc
+
+import "testing"
+
+func TestCallLimitsDefaultRequestSize(t *testing.T) {
+	proxyMap := map[string]interface{}{
+		"SERVICES": map[string]interface{}{
+			"LIST": []interface{}{map[string]interface{}{
+				"NAME": "file.Storage",
+				"METHODS": []interface{}{map[string]interface{}{
+					"NAME":   "Upload",
+					"LIMITS": map[string]interface{}{"MAX_REQUEST_SIZE": 64 << 20},
+				}},
+			}},
+		},
+	}
+	if size := ServerMaxRecvSize(proxyMap); size != 64<<20 {
+		t.Errorf("server max recv size %d, want %d", size, 64<<20)
+	}
+	services, err := parseProxyServices(proxyMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProxy(t, "limits", nil)
+
+	upload, _ := services.lookup("/file.Storage/Upload")
+	if limits := callLimits("limits", upload); limits.MaxRequestSize != 64<<20 {
+		t.Errorf("upload max request size %d, want %d", limits.MaxRequestSize, 64<<20)
+	}
+	// methods without limits keep the default of grpc server
+	download, _ := services.lookup("/file.Storage/Download")
+	for _, policy := range []*MethodPolicy{download, nil} {
+		if limits := callLimits("limits", policy); limits.MaxRequestSize != defaultServerMaxRecvSize {
+			t.Errorf("max request size %d, want %d", limits.MaxRequestSize, defaultServerMaxRecvSize)
+		}
+	}
+
+	connLock.Lock()
+	connProxy["limits"]["limits"] = &MessageLimits{MaxRequestSize: 1 << 20, MaxMessages: 10}
+	connLock.Unlock()
+	if limits := callLimits("limits", nil); limits.MaxRequestSize != 1<<20 || limits.MaxMessages != 10 {
+		t.Errorf("limits %+v, want proxy limits", limits)
+	}
+}
@@ -0,0 +1,220 @@
+package grpc
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/status"
+)
+
+// label value of methods over the cardinality limit
+const METRICS_OTHER_METHOD = "other"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+	metricsOnce     sync.Once
+	// max distinct method label values, 0 means no limit
+	metricsMaxMethods int = 1000
+	// whether endpoint label is set, endpoints of a large cluster can be disabled
+	metricsEndpointLabel bool = true
+	metricsMethods            = make(map[string]bool)
+	metricsMethodsLock   sync.Mutex
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synapsor_grpc_requests_total",
+		Help: "Proxied gRPC calls by proxy, method, endpoint and status code.",
+	}, []string{"proxy", "method", "endpoint", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "synapsor_grpc_request_duration_seconds",
+		Help:    "Duration of proxied gRPC calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"proxy", "method", "endpoint"})
+	activeStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "synapsor_grpc_active_streams",
+		Help: "Proxied gRPC calls in flight.",
+	}, []string{"proxy", "method"})
+	messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synapsor_grpc_messages_total",
+		Help: "Messages received from (in) and sent to (out) clients.",
+	}, []string{"proxy", "method", "direction"})
+	bytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synapsor_grpc_bytes_total",
+		Help: "Message bytes received from (in) and sent to (out) clients.",
+	}, []string{"proxy", "method", "direction"})
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synapsor_grpc_retries_total",
+		Help: "Retry attempts of method policies.",
+	}, []string{"proxy", "method"})
+	coalescedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synapsor_grpc_coalesced_total",
+		Help: "Coalesced unary calls, role is leader (backend called) or follower (joined an in-flight call).",
+	}, []string{"proxy", "method", "role"})
+	limitExceededTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synapsor_grpc_limit_exceeded_total",
+		Help: "Calls ended with RESOURCE_EXHAUSTED by message limits, limit is request_size, response_size, messages or stream_bytes.",
+	}, []string{"proxy", "method", "limit"})
+	acquireDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "synapsor_pool_acquire_duration_seconds",
+		Help:    "Time waiting to acquire a connection from endpoint pool.",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	}, []string{"proxy", "endpoint"})
+
+	poolConnectionsDesc = prometheus.NewDesc("synapsor_pool_connections",
+		"Current connections of endpoint pool.", []string{"proxy", "endpoint"}, nil)
+	poolCapacityDesc = prometheus.NewDesc("synapsor_pool_capacity",
+		"Capacity of endpoint pool.", []string{"proxy", "endpoint"}, nil)
+	poolHealthyDesc = prometheus.NewDesc("synapsor_pool_healthy",
+		"Healthy endpoint pools, 1 if the endpoint is healthy.", []string{"proxy", "endpoint"}, nil)
+	poolStreamsDesc = prometheus.NewDesc("synapsor_pool_streams",
+		"Calls in progress on connections of endpoint pool.", []string{"proxy", "endpoint"}, nil)
+)
+
+// pool collector reads pools at scrape time
+type poolCollector struct{}
+
+func init() {
+	metricsRegistry.MustRegister(
+		requestsTotal,
+		requestDuration,
+		activeStreams,
+		messagesTotal,
+		bytesTotal,
+		retriesTotal,
+		coalescedTotal,
+		limitExceededTotal,
+		acquireDuration,
+		poolCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// load metrics config, env overrides config file
+func loadMetrics() {
+	maxMethods := os.Getenv("METRICS_MAX_METHODS")
+	if maxMethods != "" {
+		metricsMaxMethods, _ = strconv.Atoi(maxMethods)
+	} else if viper.IsSet("METRICS_MAX_METHODS") {
+		metricsMaxMethods = viper.GetInt("METRICS_MAX_METHODS")
+	}
+	endpointLabel := os.Getenv("METRICS_ENDPOINT_LABEL")
+	if endpointLabel != "" {
+		metricsEndpointLabel, _ = strconv.ParseBool(endpointLabel)
+	} else if viper.IsSet("METRICS_ENDPOINT_LABEL") {
+		metricsEndpointLabel = viper.GetBool("METRICS_ENDPOINT_LABEL")
+	}
+}
+
+// prometheus http handler
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// method label value, methods over the limit share one label value
+func metricsMethod(fullMethodName string) string {
+	metricsOnce.Do(loadMetrics)
+	metricsMethodsLock.Lock()
+	defer metricsMethodsLock.Unlock()
+	if metricsMethods[fullMethodName] {
+		return fullMethodName
+	}
+	if metricsMaxMethods > 0 && len(metricsMethods) >= metricsMaxMethods {
+		return METRICS_OTHER_METHOD
+	}
+	metricsMethods[fullMethodName] = true
+	return fullMethodName
+}
+
+// endpoint label value
+func metricsEndpoint(endpoint string) string {
+	metricsOnce.Do(loadMetrics)
+	if !metricsEndpointLabel {
+		return ""
+	}
+	return endpoint
+}
+
+// call started
+func streamStarted(proxyName, fullMethodName string) {
+	activeStreams.WithLabelValues(proxyName, metricsMethod(fullMethodName)).Inc()
+}
+
+// call finished
+func observeCall(fullMethodName string, start time.Time, stats *callStats, err error) {
+	method := metricsMethod(fullMethodName)
+	endpoint, _ := stats.endpoint.Load().(string)
+	endpoint = metricsEndpoint(endpoint)
+	activeStreams.WithLabelValues(stats.proxy, method).Dec()
+	requestsTotal.WithLabelValues(stats.proxy, method, endpoint, status.Code(err).String()).Inc()
+	requestDuration.WithLabelValues(stats.proxy, method, endpoint).Observe(time.Since(start).Seconds())
+	messagesTotal.WithLabelValues(stats.proxy, method, "in").Add(float64(atomic.LoadInt64(&stats.messagesIn)))
+	messagesTotal.WithLabelValues(stats.proxy, method, "out").Add(float64(atomic.LoadInt64(&stats.messagesOut)))
+	bytesTotal.WithLabelValues(stats.proxy, method, "in").Add(float64(atomic.LoadInt64(&stats.bytesIn)))
+	bytesTotal.WithLabelValues(stats.proxy, method, "out").Add(float64(atomic.LoadInt64(&stats.bytesOut)))
+	if retries := atomic.LoadInt64(&stats.retries); retries > 0 {
+		retriesTotal.WithLabelValues(stats.proxy, method).Add(float64(retries))
+	}
+}
+
+// coalesced call, joined if it joined an in-flight call
+func observeCoalesce(proxyName, fullMethodName string, joined bool) {
+	role := "leader"
+	if joined {
+		role = "follower"
+	}
+	coalescedTotal.WithLabelValues(proxyName, metricsMethod(fullMethodName), role).Inc()
+}
+
+// call ended by message limit
+func observeLimitExceeded(proxyName, fullMethodName, limit string) {
+	limitExceededTotal.WithLabelValues(proxyName, metricsMethod(fullMethodName), limit).Inc()
+}
+
+// time waiting for pool acquire
+func observeAcquire(proxyName string, pool *Pool, d time.Duration) {
+	acquireDuration.WithLabelValues(proxyName, metricsEndpoint(pool.poolRemoteAddr)).Observe(d.Seconds())
+}
+
+// describe pool metrics
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolConnectionsDesc
+	ch <- poolCapacityDesc
+	ch <- poolHealthyDesc
+	ch <- poolStreamsDesc
+}
+
+// collect pool metrics, pools of the same label values are summed
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	type poolKey struct{ proxy, endpoint string }
+	connections := make(map[poolKey]float64)
+	capacity := make(map[poolKey]float64)
+	healthy := make(map[poolKey]float64)
+	streams := make(map[poolKey]float64)
+	connLock.RLock()
+	for proxyName, pools := range connPools {
+		for _, pool := range pools {
+			key := poolKey{proxyName, metricsEndpoint(pool.poolRemoteAddr)}
+			connections[key] += float64(pool.GetConnCurrent())
+			capacity[key] += float64(pool.capacity)
+			streams[key] += float64(pool.ActiveStreams())
+			if pool.status {
+				healthy[key] += 1
+			}
+		}
+	}
+	connLock.RUnlock()
+	for key, value := range connections {
+		ch <- prometheus.MustNewConstMetric(poolConnectionsDesc, prometheus.GaugeValue, value, key.proxy, key.endpoint)
+		ch <- prometheus.MustNewConstMetric(poolCapacityDesc, prometheus.GaugeValue, capacity[key], key.proxy, key.endpoint)
+		ch <- prometheus.MustNewConstMetric(poolHealthyDesc, prometheus.GaugeValue, healthy[key], key.proxy, key.endpoint)
+		ch <- prometheus.MustNewConstMetric(poolStreamsDesc, prometheus.GaugeValue, streams[key], key.proxy, key.endpoint)
+	}
+}
@@ -0,0 +1,285 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// mirror stats type
+const (
+	MIRROR_PRIMARY = "primary"
+	MIRROR_SHADOW  = "shadow"
+)
+
+// MirrorPolicy 流量镜像策略, 按比例复制请求到 shadow proxy
+type MirrorPolicy struct {
+	ProxyName  string        // shadow proxy 名称
+	Percentage float64       // 镜像比例 (0 - 100)
+	Methods    []string      // 镜像的方法, 支持前缀匹配, 为空或 "*" 时镜像全部方法
+	BufferSize int           // 等待发送给 shadow 的消息缓存数量
+	Timeout    time.Duration // shadow 请求超时时间
+}
+
+// MirrorStat 镜像对比统计
+type MirrorStat struct {
+	Requests       int64            `json:"requests"`
+	Codes          map[string]int64 `json:"codes"`
+	TotalLatency   int64            `json:"totalLatency"`
+	AverageLatency int64            `json:"averageLatency"`
+}
+
+// mirror stats, key: proxy name -> method -> primary/shadow
+var mirrorStats = make(map[string]map[string]map[string]*MirrorStat)
+var mirrorStatsLock sync.Mutex
+
+// mirrorStream 发送给 shadow proxy 的请求流, 不影响主请求
+type mirrorStream struct {
+	policy    *MirrorPolicy
+	proxyName string
+	method    string
+	md        metadata.MD
+	frames    chan *frame
+	ctx       context.Context
+	cancel    context.CancelFunc
+	lock      sync.Mutex
+	closed    bool
+	dropped   int32
+}
+
+// parse mirror policy from proxy config, nil if not set
+func parseMirrorPolicy(proxyMap map[string]interface{}) (*MirrorPolicy, error) {
+	mirrorMap := configMap(proxyMap, "MIRROR")
+	if mirrorMap == nil {
+		return nil, nil
+	}
+	methods, ok := configStringList(mirrorMap, "METHODS")
+	if !ok {
+		return nil, ErrRouteInvalid
+	}
+	policy := &MirrorPolicy{
+		ProxyName:  configString(mirrorMap, "PROXY_NAME", ""),
+		Percentage: configFloat(mirrorMap, "PERCENTAGE", 0),
+		BufferSize: configInt(mirrorMap, "BUFFER_SIZE", 64),
+		Timeout:    time.Duration(configInt(mirrorMap, "TIMEOUT", 10)) * time.Second,
+		Methods:    methods,
+	}
+	if policy.ProxyName == "" || policy.Percentage <= 0 {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// whether method need mirror
+func (policy *MirrorPolicy) matchMethod(fullMethodName string) bool {
+	if len(policy.Methods) == 0 {
+		return true
+	}
+	for _, m := range policy.Methods {
+		if m == "*" || strings.HasPrefix(fullMethodName, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// new mirror stream, return nil if the call should not be mirrored
+func newMirrorStream(ctx context.Context, fullMethodName string) *mirrorStream {
+	md, _ := metadata.FromIncomingContext(ctx)
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok {
+		return nil
+	}
+	connLock.RLock()
+	policy, ok := connProxy[proxyName]["mirrorPolicy"].(*MirrorPolicy)
+	if ok && policy != nil {
+		_, ok = connProxy[policy.ProxyName]
+	}
+	connLock.RUnlock()
+	if !ok || policy == nil || !policy.matchMethod(fullMethodName) || rand.Float64()*100 >= policy.Percentage {
+		return nil
+	}
+	md = md.Copy()
+	md.Set("proxy", policy.ProxyName)
+	md.Set("x-synapsor-shadow", "true")
+	// shadow request should not be canceled by primary request
+	mirrorCtx, cancel := context.WithTimeout(context.Background(), policy.Timeout)
+	m := &mirrorStream{
+		policy:    policy,
+		proxyName: proxyName,
+		method:    fullMethodName,
+		md:        md,
+		frames:    make(chan *frame, policy.BufferSize),
+		ctx:       metadata.NewOutgoingContext(mirrorCtx, md),
+		cancel:    cancel,
+	}
+	go m.run()
+	return m
+}
+
+// send frame to shadow without blocking
+func (m *mirrorStream) send(f *frame) {
+	if m == nil || atomic.LoadInt32(&m.dropped) == 1 {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.frames <- &frame{payload: f.payload}:
+	default:
+		// shadow is too slow, give up this mirror call
+		m.abort()
+	}
+}
+
+// client send finished
+func (m *mirrorStream) closeSend() {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.frames)
+	}
+}
+
+// abort mirror call, the result is not recorded
+func (m *mirrorStream) abort() {
+	if m == nil {
+		return
+	}
+	atomic.StoreInt32(&m.dropped, 1)
+	m.cancel()
+}
+
+// record primary result
+func (m *mirrorStream) recordPrimary(err error, duration time.Duration) {
+	if m == nil || atomic.LoadInt32(&m.dropped) == 1 {
+		return
+	}
+	recordMirrorStat(m.proxyName, m.method, MIRROR_PRIMARY, err, duration)
+}
+
+// run shadow call
+func (m *mirrorStream) run() {
+	defer m.cancel()
+	start := time.Now()
+	err := m.call()
+	if err == io.EOF {
+		err = nil
+	}
+	if atomic.LoadInt32(&m.dropped) == 1 {
+		return
+	}
+	recordMirrorStat(m.proxyName, m.method, MIRROR_SHADOW, err, time.Since(start))
+}
+
+// forward frames to shadow and discard responses
+func (m *mirrorStream) call() error {
+	pools, proxyModel := routePools(m.policy.ProxyName, m.md)
+	if len(pools) < 1 {
+		return status.Errorf(codes.Unavailable, "no endpoint available for shadow proxy %s", m.policy.ProxyName)
+	}
+	client, err := balancePool(pools, proxyModel).Acquire(m.ctx)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return status.Errorf(codes.Unavailable, "shadow proxy %s acquire client failed", m.policy.ProxyName)
+	}
+	defer client.Close()
+
+	clientStream, err := grpc.NewClientStream(m.ctx, clientStreamDescForProxying, client.ClientConn, m.method)
+	if err != nil {
+		return err
+	}
+	for sending := true; sending; {
+		select {
+		case f, ok := <-m.frames:
+			if !ok {
+				sending = false
+				break
+			}
+			if err := clientStream.SendMsg(f); err != nil {
+				// the real error is returned by RecvMsg
+				sending = false
+			}
+		case <-m.ctx.Done():
+			return m.ctx.Err()
+		}
+	}
+	clientStream.CloseSend()
+	// discard responses
+	f := &frame{}
+	for {
+		if err := clientStream.RecvMsg(f); err != nil {
+			return err
+		}
+	}
+}
+
+// record mirror stat
+func recordMirrorStat(proxyName, method, side string, err error, duration time.Duration) {
+	mirrorStatsLock.Lock()
+	defer mirrorStatsLock.Unlock()
+
+	if _, ok := mirrorStats[proxyName]; !ok {
+		mirrorStats[proxyName] = make(map[string]map[string]*MirrorStat)
+	}
+	if _, ok := mirrorStats[proxyName][method]; !ok {
+		mirrorStats[proxyName][method] = make(map[string]*MirrorStat)
+	}
+	stat, ok := mirrorStats[proxyName][method][side]
+	if !ok {
+		stat = &MirrorStat{Codes: make(map[string]int64)}
+		mirrorStats[proxyName][method][side] = stat
+	}
+	stat.Requests += 1
+	stat.Codes[status.Code(err).String()] += 1
+	stat.TotalLatency += duration.Milliseconds()
+	stat.AverageLatency = stat.TotalLatency / stat.Requests
+	vsDebug := os.Getenv("VS_DEBUG")
+	if vsDebug == "true" && err != nil && side == MIRROR_SHADOW {
+		logging.DEBUG.Debug("print debug log mirror shadow call ", method, " failed: ", err)
+	}
+}
+
+// get mirror metrics data
+func GetMirrorMetricsData() map[string]map[string]map[string]MirrorStat {
+	mirrorStatsLock.Lock()
+	defer mirrorStatsLock.Unlock()
+
+	data := make(map[string]map[string]map[string]MirrorStat)
+	for proxyName, methods := range mirrorStats {
+		data[proxyName] = make(map[string]map[string]MirrorStat)
+		for method, sides := range methods {
+			data[proxyName][method] = make(map[string]MirrorStat)
+			for side, stat := range sides {
+				statCodes := make(map[string]int64)
+				for k, v := range stat.Codes {
+					statCodes[k] = v
+				}
+				s := *stat
+				s.Codes = statCodes
+				data[proxyName][method][side] = s
+			}
+		}
+	}
+	return data
+}
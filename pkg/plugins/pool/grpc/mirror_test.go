@@ -0,0 +1,29 @@
+package grpc
+
+import "testing"
+
+func TestParseMirrorPolicy(t *testing.T) {
+	policy, err := parseMirrorPolicy(map[string]interface{}{
+		"MIRROR": map[string]interface{}{
+			"PROXY_NAME": "shadow",
+			"PERCENTAGE": 12.5,
+			"METHODS":    []interface{}{"/hello.Greeter/", 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Percentage != 12.5 || len(policy.Methods) != 2 || policy.Methods[1] != "1" {
+		t.Errorf("policy %+v", policy)
+	}
+
+	// methods must be strings
+	for _, method := range []interface{}{map[string]interface{}{"NAME": "x"}, []interface{}{"x"}, nil} {
+		_, err := parseMirrorPolicy(map[string]interface{}{
+			"MIRROR": map[string]interface{}{"PROXY_NAME": "shadow", "PERCENTAGE": 10, "METHODS": []interface{}{method}},
+		})
+		if err != ErrRouteInvalid {
+			t.Errorf("method %v error %v, want %v", method, err, ErrRouteInvalid)
+		}
+	}
+}
@@ -13,10 +13,14 @@ import (
 // 连接池初始化出错
 var ErrPoolInit = errors.New("Pool init error")
 
+// 连接池已关闭
+var errPoolClosed = errors.New("Pool is closed")
+
 // 连接池模型
 const (
 	STRICT_MODE = iota
 	LOOSE_MODE
+	MULTIPLEX_MODE
 	STRICT_NETWORK_MODE = 1
 	GLOBAL_NETWORK_MODE = 2
 )
@@ -27,22 +31,29 @@ type Pool struct {
 	proxyModel              string // 连接池负载模式
 	code                    string // 连接池 code
 	clients                 chan *Client
-	connCurrent             int32         // 当前连接数
-	capacity                int32         // 容量
-	weight                  int32         // 权重
-	size                    int32         // 容量大小 (动态变化)
-	idleDur                 time.Duration // 空闲时间
-	maxLifeDur              time.Duration // 最大连接时间
-	timeout                 time.Duration // Pool 的关闭超时时间
-	factor                  Factory       // gRPC 工厂函数
-	lock                    sync.RWMutex  // 读写锁
-	mode                    int           // 连接池 模型
-	poolRemoteAddr          string        // 远程连接地址
-	averageRequestTimeTotal int64         // 耗时统计
-	averageRequestTime      int64         // 平均耗时
-	averageRequestTimeNum   int64         // 耗时计算数量单元
-	sumRequestTimes         int64         // 总请求次数
-	status                  bool          // 是否可用
+	connCurrent             int32             // 当前连接数
+	capacity                int32             // 容量
+	weight                  int32             // 权重
+	discovered              bool              // 是否由服务发现创建
+	size                    int32             // 容量大小 (动态变化)
+	idleDur                 time.Duration     // 空闲时间
+	maxLifeDur              time.Duration     // 最大连接时间
+	timeout                 time.Duration     // Pool 的关闭超时时间
+	factor                  Factory           // gRPC 工厂函数
+	lock                    sync.RWMutex      // 读写锁
+	mode                    int               // 连接池 模型
+	poolRemoteAddr          string            // 远程连接地址
+	averageRequestTimeTotal int64             // 耗时统计
+	averageRequestTime      int64             // 平均耗时
+	averageRequestTimeNum   int64             // 耗时计算数量单元
+	sumRequestTimes         int64             // 总请求次数
+	status                  bool              // 是否可用
+	labels                  map[string]string // endpoint 标签
+	maxStreams              int32             // 多路复用模式每个连接的最大并发调用数
+	muxConns                []*muxConn        // 多路复用模式的连接
+	muxLock                 sync.Mutex        // 多路复用模式的锁
+	muxWaiters              []chan *muxConn   // 多路复用模式等待调用的队列
+	muxClosed               bool              // 多路复用模式是否关闭
 }
 
 // Client 封装的 grpc.ClientConn
@@ -51,6 +62,8 @@ type Client struct {
 	timeUsed time.Time
 	timeInit time.Time
 	pool     *Pool
+	mux      *muxConn // 多路复用模式共享的连接
+	released int32    // 多路复用模式调用是否已结束
 }
 
 // gRPC 连接工厂方法
@@ -88,6 +101,17 @@ func initPool(factor Factory, init, size int32, idleDur, maxLifeDur, timeout tim
 		mode:       mode,
 		status:     true,
 	}
+	// multiplex mode starts with one connection, more are created when saturated
+	if mode == MULTIPLEX_MODE {
+		pool.muxLock.Lock()
+		defer pool.muxLock.Unlock()
+		if init > 0 {
+			if _, err := pool.createMuxConn(); err != nil {
+				return nil, ErrPoolInit
+			}
+		}
+		return pool, nil
+	}
 	// init client
 	for i := int32(0); i < init; i++ {
 		client, err := pool.createClient()
@@ -119,7 +143,10 @@ func (pool *Pool) createClient() (*Client, error) {
 // 从连接池取出一个连接
 func (pool *Pool) Acquire(ctx context.Context) (*Client, error) {
 	if pool.IsClose() {
-		return nil, errors.New("Pool is closed")
+		return nil, errPoolClosed
+	}
+	if pool.mode == MULTIPLEX_MODE {
+		return pool.acquireStream(ctx)
 	}
 
 	// defer func() {
@@ -171,6 +198,9 @@ func (pool *Pool) Close() {
 
 	clients := pool.clients
 	pool.clients = nil
+	if pool.mode == MULTIPLEX_MODE {
+		pool.closeMux()
+	}
 
 	// 异步处理池里的连接
 	go func() {
@@ -185,13 +215,36 @@ func (pool *Pool) Close() {
 	pool.status = false
 }
 
+// 连接池关闭, 同步销毁池里的连接
+func (pool *Pool) CloseWait() {
+	pool.lock.Lock()
+	clients := pool.clients
+	pool.clients = nil
+	pool.status = false
+	pool.lock.Unlock()
+	if pool.mode == MULTIPLEX_MODE {
+		pool.closeMux()
+	}
+
+	for len(clients) > 0 {
+		client := <-clients
+		if client != nil {
+			client.Destory()
+		}
+	}
+}
+
 // 连接池是否关闭
 func (pool *Pool) IsClose() bool {
 	return pool == nil || pool.clients == nil
 }
 
-// 连接池中连接数
+// 连接池中连接数, 多路复用模式为可用的调用数
 func (pool *Pool) Size() int {
+	if pool.mode == MULTIPLEX_MODE {
+		_, _, free := pool.muxStats()
+		return free
+	}
 	pool.lock.RLock()
 	defer pool.lock.RUnlock()
 
@@ -200,11 +253,36 @@ func (pool *Pool) Size() int {
 
 // 实际连接数
 func (pool *Pool) GetConnCurrent() int32 {
+	if pool.mode == MULTIPLEX_MODE {
+		conns, _, _ := pool.muxStats()
+		return int32(conns)
+	}
 	return pool.capacity - int32(pool.Size())
 }
 
+// 进行中的调用数
+func (pool *Pool) ActiveStreams() int32 {
+	if pool.mode == MULTIPLEX_MODE {
+		_, streams, _ := pool.muxStats()
+		return int32(streams)
+	}
+	return pool.GetConnCurrent()
+}
+
+// 设置多路复用模式每个连接的最大并发调用数, 服务端声明的 MAX_CONCURRENT_STREAMS 更小时使用服务端的值
+func (pool *Pool) SetMaxConcurrentStreams(n int32) {
+	pool.muxLock.Lock()
+	defer pool.muxLock.Unlock()
+	pool.maxStreams = n
+}
+
 // 连接关闭
 func (client *Client) Close() {
+	// multiplex mode releases the stream, the connection is shared
+	if client.mux != nil {
+		client.pool.releaseStream(client)
+		return
+	}
 	go func() {
 		pool := client.pool
 		now := time.Now()
@@ -5,10 +5,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strconv"
-	"strings"
 	"synapsor/pkg/core/common"
 	logging "synapsor/pkg/core/log"
 	"synapsor/pkg/plugins"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
@@ -28,6 +28,9 @@ var connPools = make(map[string]map[string]*Pool)
 // conn proxy
 var connProxy = make(map[string]map[string]interface{})
 
+// conn pools and proxy lock
+var connLock sync.RWMutex
+
 // failed pool status
 var failedPoolStatus bool = false
 
@@ -62,6 +65,7 @@ func InitGrpcConnPool() {
 	// proxyConfig := routerViper.AllSettings()["proxy"].([]interface{})
 	rvRoot := routerViper.AllSettings()["proxy"]
 	proxyConfig := rvRoot.(map[string]interface{})["proxy_list"].([]interface{})
+	xdsConfig := configMap(rvRoot.(map[string]interface{}), "xds")
 	for _, v := range proxyConfig {
 		proxyMap := v.(map[string]interface{})
 		poolEnabled := proxyMap["ENABLED"].(bool)
@@ -77,50 +81,154 @@ func InitGrpcConnPool() {
 		poolModel := proxyMap["POOL_MODEL"].(int)
 		proxyName := proxyMap["PROXY_NAME"].(string)
 		proxyModel := proxyMap["PROXY_MODEL"].(string)
+		maxConcurrentStreams := configInt(proxyMap, "MAX_CONCURRENT_STREAMS", defaultMaxConcurrentStreams)
 		// setting default proxy
 		if len(proxyConfig) == 1 && proxyName == "default" {
 			defaultProxy = true
 		}
+		// route table
+		routeTable, err := parseRouteTable(proxyMap)
+		if err != nil {
+			logging.ERROR.Error("init grpc route table error, proxy ", proxyName, ": ", err)
+			continue
+		}
+		// mirror policy
+		mirrorPolicy, err := parseMirrorPolicy(proxyMap)
+		if err != nil {
+			logging.ERROR.Error("init grpc mirror policy error, proxy ", proxyName, ": ", err)
+			continue
+		}
+		// fault rules
+		faultRules, err := parseFaultRules(proxyName, proxyMap)
+		if err != nil {
+			logging.ERROR.Error("init grpc fault rules error, proxy ", proxyName, ": ", err)
+			continue
+		}
+		// declared services and method policies
+		services, err := parseProxyServices(proxyMap)
+		if err != nil {
+			logging.ERROR.Error("init grpc services error, proxy ", proxyName, ": ", err)
+			continue
+		}
+		// compression of backend calls
+		compression := parseCompressionPolicy(proxyMap)
+		// proxy pool init map, shared by all endpoints of the proxy
+		proxyInitMap := map[string]interface{}{
+			"grpcRequestReusable":  grpcRequestReusable,
+			"requestIdleTime":      requestIdleTime,
+			"requestMaxLife":       requestMaxLife,
+			"requestTimeout":       requestTimeout,
+			"poolEnabled":          poolEnabled,
+			"connNum":              defaultGrpcConnNum,
+			"proxyName":            proxyName,
+			"poolModel":            poolModel,
+			"proxyModel":           proxyModel,
+			"maxConcurrentStreams": maxConcurrentStreams,
+		}
+		// protobuf descriptors, transcoding descriptor config is used if DESCRIPTORS not set
+		descriptorsMap := configMap(proxyMap, "DESCRIPTORS")
+		if descriptorsMap == nil {
+			descriptorsMap = configMap(proxyMap, "TRANSCODING")
+		}
+		if err := initProxyDescriptors(proxyName, descriptorsMap); err != nil {
+			logging.ERROR.Error("init grpc descriptors error, proxy ", proxyName, ": ", err)
+			continue
+		}
+		initGrpcProxy(proxyName, proxyInitMap)
+		// endpoints from discovery provider instead of static list
+		discoveryMap := configMap(proxyMap, "DISCOVERY")
+		staticEndpoints := configList(proxyMap, "GRPC_PROXY_ENDPOINTS")
+		if discoveryMap != nil && len(staticEndpoints) > 0 {
+			logging.Log.Info("proxy ", proxyName, " use discovery, GRPC_PROXY_ENDPOINTS ignored")
+			staticEndpoints = nil
+		}
 		// proxy map loop
-		for _, endPoint := range proxyMap["GRPC_PROXY_ENDPOINTS"].([]interface{}) {
+		for _, endPoint := range staticEndpoints {
 			endPointStr := endPoint.(string)
-			endPointList := strings.Split(endPointStr, "#")
-			if len(endPointList) < 2 {
+			endpoint, err := parseEndpoint(endPointStr)
+			if err != nil {
 				logging.ERROR.Error("init grpc connection error, config env invaild...")
 				break
 			}
 
-			poolInitMap := map[string]interface{}{
-				"grpcRequestReusable": grpcRequestReusable,
-				"requestIdleTime":     requestIdleTime,
-				"requestMaxLife":      requestMaxLife,
-				"requestTimeout":      requestTimeout,
-				"serverHost":          endPointList[0],
-				"gatewayProxyPort":    endPointList[0],
-				"poolEnabled":         poolEnabled,
-			}
-
-			poolInitMap["connNum"] = defaultGrpcConnNum
-			poolInitMap["serverName"] = endPointList[0]
-			poolInitMap["serverHost"] = endPointList[0]
-			poolInitMap["proxyName"] = proxyName
-			poolInitMap["proxyWeight"] = endPointList[1]
-			poolInitMap["serviceCode"] = common.GenXid()
-			poolInitMap["poolModel"] = poolModel
-			poolInitMap["proxyModel"] = proxyModel
-
+			poolInitMap := newPoolInitMap(proxyInitMap, endpoint)
 			initGrpcProxyPool(poolInitMap)
 
 			logging.DEBUG.Debug("init grpc connection ", poolInitMap["serverName"], " finish ...")
 		}
+		// setting proxy route table, mirror policy and fault rules
+		connLock.Lock()
+		connProxy[proxyName]["routeTable"] = routeTable
+		connProxy[proxyName]["mirrorPolicy"] = mirrorPolicy
+		connProxy[proxyName]["faultRules"] = faultRules
+		connProxy[proxyName]["services"] = services
+		connProxy[proxyName]["compression"] = compression
+		connProxy[proxyName]["limits"] = parseProxyLimits(proxyMap)
+		connLock.Unlock()
+		// endpoint discovery
+		if discoveryMap != nil {
+			startDiscovery(proxyName, discoveryMap)
+		}
+		// descriptors to decode route fields of request and to know unary methods
+		if routeTable.needsFields() || services.needsUnary() || (compression != nil && compression.MinSize > 0) {
+			watchProxyDescriptors(proxyName)
+		}
+		// auto discover services by descriptors
+		if services != nil && services.AutoDiscover {
+			startServiceDiscovery(proxyName)
+		}
+		// http/json transcoding
+		if transcodeMap := configMap(proxyMap, "TRANSCODING"); transcodeMap != nil && configBool(transcodeMap, "ENABLED", false) {
+			initTranscoding(proxyName, transcodeMap)
+		}
+	}
+	// proxies and endpoints from xds control plane
+	if xdsConfig != nil && configBool(xdsConfig, "enabled", false) {
+		startXdsClient(xdsConfig)
 	}
 }
 
-// new grpc pool
-func newGrpcPool(address string, option Options) (*Pool, error) {
+// init grpc proxy
+func initGrpcProxy(proxyName string, proxyInitMap map[string]interface{}) {
+	connLock.Lock()
+	defer connLock.Unlock()
+
+	if _, ok := connPools[proxyName]; !ok {
+		connPools[proxyName] = make(map[string]*Pool)
+	}
+	if _, ok := connProxy[proxyName]; !ok {
+		connProxy[proxyName] = make(map[string]interface{})
+		connProxy[proxyName]["proxyModel"] = proxyInitMap["proxyModel"]
+		connProxy[proxyName]["proxyInitMap"] = proxyInitMap
+	}
+}
+
+// new pool init map of endpoint
+func newPoolInitMap(proxyInitMap map[string]interface{}, endpoint Endpoint) map[string]interface{} {
+	poolInitMap := make(map[string]interface{})
+	for k, v := range proxyInitMap {
+		poolInitMap[k] = v
+	}
+	poolInitMap["serverName"] = endpoint.Addr
+	poolInitMap["serverHost"] = endpoint.Addr
+	poolInitMap["gatewayProxyPort"] = endpoint.Addr
+	poolInitMap["proxyWeight"] = strconv.Itoa(int(endpoint.Weight))
+	poolInitMap["labels"] = endpoint.Labels
+	poolInitMap["serviceCode"] = common.GenXid()
+	return poolInitMap
+}
+
+// NewGrpcPool 按 Options 创建 endpoint 的连接池
+func NewGrpcPool(address string, option Options) (*Pool, error) {
 	dial := func() (*grpc.ClientConn, error) {
 		return grpcDial(address)
 	}
+	// server settings are read to limit streams of shared connections
+	if option.PoolModel == MULTIPLEX_MODE {
+		dial = func() (*grpc.ClientConn, error) {
+			return grpcDialMux(address)
+		}
+	}
 
 	gp, err := NewPool(
 		dial,
@@ -131,11 +239,17 @@ func newGrpcPool(address string, option Options) (*Pool, error) {
 		time.Duration(option.RequestTimeOut)*time.Second,
 		option.PoolModel,
 	)
+	if err == nil {
+		gp.SetMaxConcurrentStreams(int32(option.MaxConcurrentStreams))
+	}
 	return gp, err
 }
 
 // release grpc pool
 func ReleaseGrpcPool(proxyName, poolName string) {
+	connLock.Lock()
+	defer connLock.Unlock()
+
 	if _, ok := connPools[proxyName][poolName]; ok {
 		connPools[proxyName][poolName].Close()
 		delete(connPools[proxyName], poolName)
@@ -147,7 +261,7 @@ func ReleaseGrpcPool(proxyName, poolName string) {
 }
 
 // init grpc pool
-func initGrpcProxyPool(data map[string]interface{}) {
+func initGrpcProxyPool(data map[string]interface{}) *Pool {
 	proxyName := data["proxyName"].(string)
 	// get concur
 	serverAddr := data["serverHost"].(string)
@@ -158,7 +272,7 @@ func initGrpcProxyPool(data map[string]interface{}) {
 		PoolModel:            data["poolModel"].(int),
 		MaxIdle:              connNum,
 		MaxActive:            connNum,
-		MaxConcurrentStreams: 0,
+		MaxConcurrentStreams: configInt(data, "maxConcurrentStreams", 0),
 		Reusable:             data["grpcRequestReusable"].(bool),
 		RequestIdleTime:      data["requestIdleTime"].(int),
 		RequestMaxLife:       data["requestMaxLife"].(int),
@@ -168,9 +282,10 @@ func initGrpcProxyPool(data map[string]interface{}) {
 		PoolStatus:           data["poolEnabled"].(bool),
 	}
 	// create pool
-	pool, err := newGrpcPool(serverAddr, op)
+	pool, err := NewGrpcPool(serverAddr, op)
 	if err != nil {
-		logging.ERROR.Error("failed to new pool: %v", err)
+		logging.ERROR.Error("failed to new pool: ", err)
+		return nil
 	}
 	// setting pool remote addr
 	pool.poolRemoteAddr = serverAddr
@@ -178,21 +293,29 @@ func initGrpcProxyPool(data map[string]interface{}) {
 	pool.code = proxyName
 	weight, _ := strconv.Atoi(data["proxyWeight"].(string))
 	pool.weight = int32(weight)
+	pool.discovered, _ = data["discovered"].(bool)
 	pool.proxyModel = data["proxyModel"].(string)
-	//init pool
-	if _, ok := connPools[proxyName]; !ok {
-		connPools[proxyName] = make(map[string]*Pool)
-		connProxy[proxyName] = make(map[string]interface{})
+	if labels, ok := data["labels"].(map[string]string); ok {
+		pool.labels = labels
 	}
+	//init pool
+	initGrpcProxy(proxyName, data)
+	connLock.Lock()
 	connPools[proxyName][pool.name] = pool
-	connProxy[proxyName]["proxyModel"] = data["proxyModel"]
+	connLock.Unlock()
+	return pool
 }
 
 // grpc dial
 func grpcDial(address string) (*grpc.ClientConn, error) {
+	return grpcDialOptions(address)
+}
+
+// grpc dial with extra dial options
+func grpcDialOptions(address string, extra ...grpc.DialOption) (*grpc.ClientConn, error) {
 	ctx, ctxCancel := context.WithTimeout(context.Background(), DialTimeout)
 	defer ctxCancel()
-	gcc, err := grpc.DialContext(ctx, address,
+	opts := []grpc.DialOption{
 		grpc.WithCodec(Codec()),
 		grpc.WithInsecure(),
 		grpc.WithBackoffMaxDelay(BackoffMaxDelay),
@@ -205,7 +328,8 @@ func grpcDial(address string) (*grpc.ClientConn, error) {
 			Timeout:             3,
 			PermitWithoutStream: true,
 		}),
-	)
+	}
+	gcc, err := grpc.DialContext(ctx, address, append(opts, extra...)...)
 
 	if err != nil {
 		logging.ERROR.Error("grpc dial failed !", err)
@@ -216,6 +340,9 @@ func grpcDial(address string) (*grpc.ClientConn, error) {
 
 // get conn pool metrics data
 func GetConnPoolMetricsData() map[string]map[string]int {
+	connLock.RLock()
+	defer connLock.RUnlock()
+
 	connDataMap := make(map[string]map[string]int)
 	for k, pools := range connPools {
 		connDataMap[k] = make(map[string]int)
@@ -0,0 +1,283 @@
+package grpc
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// default max concurrent streams of a connection in multiplex mode
+const defaultMaxConcurrentStreams = 100
+
+// max concurrent streams advertised by servers, *grpc.ClientConn -> *serverSettings
+var serverStreamLimits sync.Map
+
+// serverSettings 服务端在 SETTINGS 帧中声明的参数, 重连后更新
+type serverSettings struct {
+	maxStreams uint32 // SETTINGS_MAX_CONCURRENT_STREAMS, 0 为未声明
+}
+
+// muxConn 多路复用模式下多个调用共享的连接
+type muxConn struct {
+	conn      *grpc.ClientConn
+	streams   int32     // 进行中的调用数
+	idleSince time.Time // 最后一个调用结束的时间
+	timeInit  time.Time
+}
+
+// conn reading the first SETTINGS frame of server
+type settingsConn struct {
+	net.Conn
+	settings *serverSettings
+	buf      []byte
+	done     bool
+}
+
+// dial option recording max concurrent streams advertised by the server
+func withServerSettings(settings *serverSettings) grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return &settingsConn{Conn: conn, settings: settings}, nil
+	})
+}
+
+// grpc dial of multiplex mode
+func grpcDialMux(address string) (*grpc.ClientConn, error) {
+	settings := &serverSettings{}
+	conn, err := grpcDialOptions(address, withServerSettings(settings))
+	if err != nil {
+		return nil, err
+	}
+	serverStreamLimits.Store(conn, settings)
+	return conn, nil
+}
+
+// read and parse the server preface
+func (c *settingsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.done && n > 0 {
+		c.parse(p[:n])
+	}
+	return n, err
+}
+
+// parse the first frame, it must be SETTINGS of the server preface
+func (c *settingsConn) parse(b []byte) {
+	c.buf = append(c.buf, b...)
+	if len(c.buf) < 9 {
+		return
+	}
+	length := int(c.buf[0])<<16 | int(c.buf[1])<<8 | int(c.buf[2])
+	if http2.FrameType(c.buf[3]) != http2.FrameSettings || http2.Flags(c.buf[4]).Has(http2.FlagSettingsAck) {
+		c.done, c.buf = true, nil
+		return
+	}
+	if len(c.buf) < 9+length {
+		return
+	}
+	var maxStreams uint32
+	for i := 9; i+6 <= 9+length; i += 6 {
+		if http2.SettingID(binary.BigEndian.Uint16(c.buf[i:])) == http2.SettingMaxConcurrentStreams {
+			maxStreams = binary.BigEndian.Uint32(c.buf[i+2:])
+		}
+	}
+	atomic.StoreUint32(&c.settings.maxStreams, maxStreams)
+	c.done, c.buf = true, nil
+}
+
+// max concurrent streams of the connection, the smaller of config and server settings
+func (pool *Pool) streamLimit(mc *muxConn) int32 {
+	limit := pool.maxStreams
+	if limit <= 0 {
+		limit = defaultMaxConcurrentStreams
+	}
+	if v, ok := serverStreamLimits.Load(mc.conn); ok {
+		if advertised := atomic.LoadUint32(&v.(*serverSettings).maxStreams); advertised > 0 && int64(advertised) < int64(limit) {
+			limit = int32(advertised)
+		}
+	}
+	return limit
+}
+
+// create connection of multiplex mode, called with muxLock held
+func (pool *Pool) createMuxConn() (*muxConn, error) {
+	conn, err := pool.factor()
+	if err != nil {
+		return nil, ErrPoolInit
+	}
+	now := time.Now()
+	mc := &muxConn{conn: conn, idleSince: now, timeInit: now}
+	pool.muxConns = append(pool.muxConns, mc)
+	return mc, nil
+}
+
+// close connection of multiplex mode
+func destroyMuxConn(mc *muxConn) {
+	serverStreamLimits.Delete(mc.conn)
+	mc.conn.Close()
+}
+
+// close connections idle over idleDur, one connection is kept, called with muxLock held
+func (pool *Pool) pruneMuxConns(now time.Time) {
+	if pool.idleDur <= 0 || len(pool.muxConns) <= 1 {
+		return
+	}
+	remaining := len(pool.muxConns)
+	conns := pool.muxConns[:0]
+	for _, mc := range pool.muxConns {
+		if mc.streams == 0 && remaining > 1 && mc.idleSince.Add(pool.idleDur).Before(now) {
+			destroyMuxConn(mc)
+			remaining--
+			continue
+		}
+		conns = append(conns, mc)
+	}
+	for i := len(conns); i < len(pool.muxConns); i++ {
+		pool.muxConns[i] = nil
+	}
+	pool.muxConns = conns
+}
+
+// take a stream of the least loaded connection, a new connection is created only when
+// all connections are saturated, calls wait in order for released streams when the pool is full
+func (pool *Pool) acquireStream(ctx context.Context) (*Client, error) {
+	pool.muxLock.Lock()
+	if pool.muxClosed {
+		pool.muxLock.Unlock()
+		return nil, errPoolClosed
+	}
+	now := time.Now()
+	pool.pruneMuxConns(now)
+	var selected *muxConn
+	for _, mc := range pool.muxConns {
+		if mc.streams < pool.streamLimit(mc) && (selected == nil || mc.streams < selected.streams) {
+			selected = mc
+		}
+	}
+	// dial does not block, the connection is established in background
+	if selected == nil && int32(len(pool.muxConns)) < pool.capacity {
+		mc, err := pool.createMuxConn()
+		if err != nil {
+			pool.muxLock.Unlock()
+			return nil, err
+		}
+		selected = mc
+	}
+	if selected != nil {
+		selected.streams++
+		pool.muxLock.Unlock()
+		return pool.newMuxClient(selected), nil
+	}
+	// all connections are saturated, released streams are handed over in order
+	waiter := make(chan *muxConn, 1)
+	pool.muxWaiters = append(pool.muxWaiters, waiter)
+	pool.muxLock.Unlock()
+	select {
+	case mc := <-waiter:
+		if mc == nil {
+			return nil, errPoolClosed
+		}
+		return pool.newMuxClient(mc), nil
+	case <-ctx.Done():
+		pool.muxLock.Lock()
+		removed := pool.removeMuxWaiter(waiter)
+		pool.muxLock.Unlock()
+		// the stream was handed over at the same time
+		if !removed {
+			if mc := <-waiter; mc != nil {
+				pool.releaseMuxStream(mc)
+			}
+		}
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// client of a stream on the shared connection
+func (pool *Pool) newMuxClient(mc *muxConn) *Client {
+	return &Client{ClientConn: mc.conn, timeUsed: time.Now(), timeInit: mc.timeInit, pool: pool, mux: mc}
+}
+
+// remove waiter of timeout call, false if the waiter was handed a stream
+func (pool *Pool) removeMuxWaiter(waiter chan *muxConn) bool {
+	for i, w := range pool.muxWaiters {
+		if w == waiter {
+			pool.muxWaiters = append(pool.muxWaiters[:i], pool.muxWaiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release the stream of the client once
+func (pool *Pool) releaseStream(client *Client) {
+	if atomic.CompareAndSwapInt32(&client.released, 0, 1) {
+		pool.releaseMuxStream(client.mux)
+	}
+}
+
+// hand the stream over to the first waiting call, or release it,
+// the connection is closed with its last stream if the pool is closed
+func (pool *Pool) releaseMuxStream(mc *muxConn) {
+	pool.muxLock.Lock()
+	defer pool.muxLock.Unlock()
+	if len(pool.muxWaiters) > 0 && !pool.muxClosed && mc.streams <= pool.streamLimit(mc) {
+		waiter := pool.muxWaiters[0]
+		pool.muxWaiters = pool.muxWaiters[1:]
+		waiter <- mc
+		return
+	}
+	mc.streams--
+	if mc.streams == 0 {
+		mc.idleSince = time.Now()
+		if pool.muxClosed {
+			destroyMuxConn(mc)
+		}
+	}
+}
+
+// close the pool of multiplex mode, connections with streams are closed when the streams end
+func (pool *Pool) closeMux() {
+	pool.muxLock.Lock()
+	defer pool.muxLock.Unlock()
+	pool.muxClosed = true
+	for _, mc := range pool.muxConns {
+		if mc.streams == 0 {
+			destroyMuxConn(mc)
+		}
+	}
+	pool.muxConns = nil
+	for _, waiter := range pool.muxWaiters {
+		waiter <- nil
+	}
+	pool.muxWaiters = nil
+}
+
+// streams of multiplex mode, connections and free streams
+func (pool *Pool) muxStats() (conns, streams, free int) {
+	pool.muxLock.Lock()
+	defer pool.muxLock.Unlock()
+	for _, mc := range pool.muxConns {
+		streams += int(mc.streams)
+		if limit := int(pool.streamLimit(mc)); limit > int(mc.streams) {
+			free += limit - int(mc.streams)
+		}
+	}
+	conns = len(pool.muxConns)
+	// connections not created yet
+	limit := int(pool.maxStreams)
+	if limit <= 0 {
+		limit = defaultMaxConcurrentStreams
+	}
+	free += (int(pool.capacity) - conns) * limit
+	return conns, streams, free
+}
@@ -0,0 +1,278 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// multiplex pool of lazy connections to an unused address
+func newTestMuxPool(t *testing.T, conns, streams int32) *Pool {
+	t.Helper()
+	pool, err := NewPool(func() (*grpc.ClientConn, error) {
+		return grpc.Dial("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}, 0, conns, 0, 0, 0, MULTIPLEX_MODE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.SetMaxConcurrentStreams(streams)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestAcquireStream(t *testing.T) {
+	pool := newTestMuxPool(t, 2, 2)
+	ctx := context.Background()
+	var clients []*Client
+	for i := 0; i < 4; i++ {
+		client, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	// a new connection is created only when all connections are saturated
+	if clients[0].mux != clients[1].mux || clients[2].mux != clients[3].mux || clients[0].mux == clients[2].mux {
+		t.Error("streams not packed into saturated connections")
+	}
+	if conns, streams, free := pool.muxStats(); conns != 2 || streams != 4 || free != 0 {
+		t.Errorf("conns %d streams %d free %d", conns, streams, free)
+	}
+
+	// the pool is full, the call waits until its deadline
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Acquire(timeoutCtx); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("acquire of full pool error %v, want DeadlineExceeded", err)
+	}
+	if len(pool.muxWaiters) != 0 {
+		t.Errorf("waiters %d after timeout", len(pool.muxWaiters))
+	}
+
+	// released stream is handed over to the waiting call
+	acquired := make(chan *Client)
+	go func() {
+		client, _ := pool.Acquire(ctx)
+		acquired <- client
+	}()
+	waitFor(t, "waiter", func() bool {
+		pool.muxLock.Lock()
+		defer pool.muxLock.Unlock()
+		return len(pool.muxWaiters) == 1
+	})
+	clients[1].Close()
+	// released once
+	clients[1].Close()
+	waiter := <-acquired
+	if waiter == nil || waiter.mux != clients[1].mux {
+		t.Fatal("released stream not handed over")
+	}
+	if _, streams, _ := pool.muxStats(); streams != 4 {
+		t.Errorf("streams %d after handover, want 4", streams)
+	}
+
+	idleSince := clients[0].mux.idleSince
+	waiter.Close()
+	clients[0].Close()
+	if _, streams, _ := pool.muxStats(); streams != 2 || clients[0].mux.streams != 0 {
+		t.Errorf("streams %d, connection streams %d", streams, clients[0].mux.streams)
+	}
+	if !clients[0].mux.idleSince.After(idleSince) {
+		t.Error("idle time not updated")
+	}
+	// the least loaded connection is selected
+	client, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.mux != clients[0].mux {
+		t.Error("stream not on the idle connection")
+	}
+}
+
+func TestReleaseMuxStreamAfterClose(t *testing.T) {
+	pool := newTestMuxPool(t, 1, 1)
+	ctx := context.Background()
+	client, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// waiting calls fail when the pool is closed
+	errs := make(chan error)
+	go func() {
+		_, err := pool.Acquire(ctx)
+		errs <- err
+	}()
+	waitFor(t, "waiter", func() bool {
+		pool.muxLock.Lock()
+		defer pool.muxLock.Unlock()
+		return len(pool.muxWaiters) == 1
+	})
+	pool.Close()
+	if err := <-errs; err != errPoolClosed {
+		t.Errorf("waiter error %v, want %v", err, errPoolClosed)
+	}
+	if _, err := pool.Acquire(ctx); err != errPoolClosed {
+		t.Errorf("acquire of closed pool error %v", err)
+	}
+	// the connection is closed with its last stream
+	client.Close()
+	if client.mux.streams != 0 {
+		t.Errorf("streams %d", client.mux.streams)
+	}
+	if state := client.ClientConn.GetState().String(); state != "SHUTDOWN" {
+		t.Errorf("connection state %s, want SHUTDOWN", state)
+	}
+}
+
+// frames written by http2 framer
+func http2Frames(t *testing.T, write func(framer *http2.Framer) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := write(http2.NewFramer(&buf, nil)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// conn reading data in chunks
+type chunkConn struct {
+	net.Conn
+	data  []byte
+	chunk int
+}
+
+func (c *chunkConn) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:c.chunk], c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// read all data through settings conn
+func readSettings(data []byte, chunk int) *settingsConn {
+	c := &settingsConn{Conn: &chunkConn{data: data, chunk: chunk}, settings: &serverSettings{maxStreams: 8}}
+	p := make([]byte, 64)
+	for {
+		if _, err := c.Read(p); err != nil {
+			return c
+		}
+	}
+}
+
+func TestSettingsConnParse(t *testing.T) {
+	settings := http2Frames(t, func(framer *http2.Framer) error {
+		return framer.WriteSettings(
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: 65535},
+			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 16},
+		)
+	})
+	ping := http2Frames(t, func(framer *http2.Framer) error { return framer.WritePing(false, [8]byte{}) })
+	// the frame is parsed across reads
+	for _, chunk := range []int{1, 5, 9, 13, 64} {
+		c := readSettings(append(append([]byte(nil), settings...), ping...), chunk)
+		if !c.done || c.settings.maxStreams != 16 || c.buf != nil {
+			t.Errorf("chunk %d: done %v max streams %d", chunk, c.done, c.settings.maxStreams)
+		}
+	}
+
+	// settings without max concurrent streams
+	c := readSettings(http2Frames(t, func(framer *http2.Framer) error {
+		return framer.WriteSettings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 65535})
+	}), 64)
+	if !c.done || c.settings.maxStreams != 0 {
+		t.Errorf("done %v max streams %d, want 0", c.done, c.settings.maxStreams)
+	}
+
+	// only the first frame of server preface is parsed
+	ack := http2Frames(t, func(framer *http2.Framer) error { return framer.WriteSettingsAck() })
+	for _, first := range [][]byte{ack, ping} {
+		c := readSettings(append(append([]byte(nil), first...), settings...), 64)
+		if !c.done || c.settings.maxStreams != 8 {
+			t.Errorf("done %v max streams %d, want 8", c.done, c.settings.maxStreams)
+		}
+	}
+}
+
+// backend echoing the request
+func startEchoBackend(b *testing.B) string {
+	b.Helper()
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		req := &wrapperspb.BytesValue{}
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		return stream.SendMsg(req)
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go srv.Serve(lis)
+	b.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// unary calls from parallel callers through pool of mode
+func benchmarkPool(b *testing.B, mode int) {
+	addr := startEchoBackend(b)
+	pool, err := NewGrpcPool(addr, Options{
+		PoolModel:            mode,
+		MaxIdle:              4,
+		MaxActive:            4,
+		MaxConcurrentStreams: 100,
+		RequestIdleTime:      10,
+		RequestMaxLife:       60,
+		RequestTimeOut:       3,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.CloseWait()
+
+	req := &wrapperspb.BytesValue{Value: make([]byte, 256)}
+	call := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		client, err := pool.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		return client.Invoke(ctx, "/bench.Echo/Call", req, &wrapperspb.BytesValue{})
+	}
+	// warm up connections
+	if err := call(); err != nil {
+		b.Fatal(err)
+	}
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := call(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkPoolStrict(b *testing.B) {
+	benchmarkPool(b, STRICT_MODE)
+}
+
+func BenchmarkPoolMultiplex(b *testing.B) {
+	benchmarkPool(b, MULTIPLEX_MODE)
+}
@@ -0,0 +1,172 @@
+package grpc
+
+import (
+	"sort"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// refresh interval of aggregated reflection descriptors
+var ReflectionRefreshInterval = 30 * time.Second
+
+// reflection request timeout of each backend
+var ReflectionTimeout = 10 * time.Second
+
+// reflectionRegistry 聚合所有 proxy 后端 server reflection 的描述符, 供 proxy 端口的 reflection 服务使用
+type reflectionRegistry struct {
+	lock       sync.RWMutex
+	refresh    sync.Mutex
+	rebuilding bool // 后台正在重新合并
+	dirty      bool // 合并期间描述符有更新, 需要再合并一次
+	files      *protoregistry.Files
+	types      *protoregistry.Types
+	services   map[string]grpc.ServiceInfo
+	updatedAt  time.Time
+}
+
+// aggregated reflection registry of all proxies
+var proxyReflection = &reflectionRegistry{
+	files:    &protoregistry.Files{},
+	types:    &protoregistry.Types{},
+	services: map[string]grpc.ServiceInfo{},
+}
+
+func init() {
+	onDescriptorsUpdate(func(proxyName string, descriptors *ProxyDescriptors) {
+		proxyReflection.rebuild(true)
+	})
+}
+
+// register reflection service answered with descriptors of all backends
+func RegisterReflection(srv *grpc.Server) {
+	rpb.RegisterServerReflectionServer(srv, reflection.NewServer(reflection.ServerOptions{
+		Services:           proxyReflection,
+		DescriptorResolver: proxyReflection,
+		ExtensionResolver:  proxyReflection,
+	}))
+	proxyReflection.rebuild(false)
+}
+
+// current snapshot of registry, rebuilt in background if expired
+func (r *reflectionRegistry) current() (*protoregistry.Files, *protoregistry.Types, map[string]grpc.ServiceInfo) {
+	r.lock.RLock()
+	files, types, services := r.files, r.types, r.services
+	expired := time.Since(r.updatedAt) > ReflectionRefreshInterval
+	r.lock.RUnlock()
+	if expired {
+		r.rebuild(false)
+	}
+	return files, types, services
+}
+
+// rebuild registry in background, changed rebuilds again if a rebuild is running
+func (r *reflectionRegistry) rebuild(changed bool) {
+	r.refresh.Lock()
+	defer r.refresh.Unlock()
+	if r.rebuilding {
+		r.dirty = r.dirty || changed
+		return
+	}
+	r.rebuilding = true
+	go func() {
+		for {
+			r.load()
+			r.refresh.Lock()
+			if !r.dirty {
+				r.rebuilding = false
+				r.refresh.Unlock()
+				return
+			}
+			r.dirty = false
+			r.refresh.Unlock()
+		}
+	}()
+}
+
+// load descriptors of all proxies, services of the first proxy win if defined twice
+func (r *reflectionRegistry) load() {
+	connLock.RLock()
+	proxyNames := make([]string, 0, len(connProxy))
+	for proxyName := range connProxy {
+		proxyNames = append(proxyNames, proxyName)
+	}
+	connLock.RUnlock()
+	sort.Strings(proxyNames)
+
+	// reflection service itself
+	reflectionFile := protodesc.ToFileDescriptorProto(rpb.File_grpc_reflection_v1alpha_reflection_proto)
+	protos := map[string]*descriptorpb.FileDescriptorProto{reflectionFile.GetName(): reflectionFile}
+	for _, proxyName := range proxyNames {
+		descriptors, ok := getProxyDescriptors(proxyName)
+		if !ok {
+			continue
+		}
+		descriptors.load()
+		for name, file := range descriptors.Protos() {
+			if _, ok := protos[name]; !ok {
+				protos[name] = file
+			}
+		}
+	}
+	files := buildFiles(protos)
+	services := make(map[string]grpc.ServiceInfo)
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			services[string(file.Services().Get(i).FullName())] = grpc.ServiceInfo{}
+		}
+		return true
+	})
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.files = files
+	r.types = descriptorTypes(files)
+	r.services = services
+	r.updatedAt = time.Now()
+	logging.Log.Info("reflection load ", len(services), " services of ", len(proxyNames), " proxies")
+}
+
+// services of all backends
+func (r *reflectionRegistry) GetServiceInfo() map[string]grpc.ServiceInfo {
+	_, _, services := r.current()
+	return services
+}
+
+// find file by path
+func (r *reflectionRegistry) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	files, _, _ := r.current()
+	return files.FindFileByPath(path)
+}
+
+// find descriptor by full name
+func (r *reflectionRegistry) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	files, _, _ := r.current()
+	return files.FindDescriptorByName(name)
+}
+
+// find extension by full name
+func (r *reflectionRegistry) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	_, types, _ := r.current()
+	return types.FindExtensionByName(field)
+}
+
+// find extension by message and field number
+func (r *reflectionRegistry) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	_, types, _ := r.current()
+	return types.FindExtensionByNumber(message, field)
+}
+
+// range extensions of message
+func (r *reflectionRegistry) RangeExtensionsByMessage(message protoreflect.FullName, f func(protoreflect.ExtensionType) bool) {
+	_, types, _ := r.current()
+	types.RangeExtensionsByMessage(message, f)
+}
@@ -0,0 +1,49 @@
+package grpc
+
+import (
+	"testing"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func TestReflectionRegistryRebuild(t *testing.T) {
+	testProxy(t, "refl", nil)
+	testDescriptors(t, "refl", healthpb.File_grpc_health_v1_health_proto)
+
+	r := &reflectionRegistry{
+		files:    &protoregistry.Files{},
+		types:    &protoregistry.Types{},
+		services: map[string]grpc.ServiceInfo{},
+	}
+	// the expired snapshot is served while rebuilding in background
+	if services := r.GetServiceInfo(); len(services) != 0 {
+		t.Errorf("services %v before rebuild", services)
+	}
+	waitFor(t, "reflection rebuild", func() bool {
+		_, ok := r.GetServiceInfo()["grpc.health.v1.Health"]
+		return ok
+	})
+	if _, err := r.FindFileByPath("grpc/health/v1/health.proto"); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.FindDescriptorByName("grpc.reflection.v1alpha.ServerReflection"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInitProxyDescriptorsConfig(t *testing.T) {
+	t.Cleanup(func() { removeProxyDescriptors("descset") })
+	if err := initProxyDescriptors("descset", map[string]interface{}{"DESCRIPTOR_SET": []interface{}{"a.pb", "b.pb"}}); err != nil {
+		t.Fatal(err)
+	}
+	descriptors, _ := getProxyDescriptors("descset")
+	if len(descriptors.descriptorSets) != 2 || descriptors.reflection {
+		t.Errorf("descriptor sets %v reflection %v", descriptors.descriptorSets, descriptors.reflection)
+	}
+	err := initProxyDescriptors("descset", map[string]interface{}{"DESCRIPTOR_SET": []interface{}{map[string]interface{}{"PATH": "a.pb"}}})
+	if err != ErrDescriptorsInvalid {
+		t.Errorf("error %v, want %v", err, ErrDescriptorsInvalid)
+	}
+}
@@ -0,0 +1,389 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// descriptor registry errors
+var (
+	ErrDescriptorsNotFound = errors.New("descriptors of proxy not found")
+	ErrMethodNotFound      = errors.New("method descriptor not found")
+	ErrDescriptorsInvalid  = errors.New("descriptors config invalid")
+)
+
+// default refresh interval of descriptors
+var DescriptorRefreshInterval = 60 * time.Second
+
+// ProxyDescriptors proxy 的 protobuf 描述符, 来自 descriptor set 文件或后端的 server reflection
+type ProxyDescriptors struct {
+	proxyName      string
+	descriptorSets []string
+	reflection     bool
+	interval       time.Duration
+	refresh        sync.Mutex
+	watchOnce      sync.Once
+	lock           sync.RWMutex
+	protos         map[string]*descriptorpb.FileDescriptorProto
+	files          *protoregistry.Files
+	types          *protoregistry.Types
+	methods        map[string]protoreflect.MethodDescriptor // key 为 /service/method
+	hash           uint64
+	updatedAt      time.Time
+	err            error
+}
+
+// DescriptorsView 描述符的展示数据
+type DescriptorsView struct {
+	Proxy          string        `json:"proxy"`
+	DescriptorSets []string      `json:"descriptorSets"`
+	Reflection     bool          `json:"reflection"`
+	UpdatedAt      string        `json:"updatedAt"`
+	Error          string        `json:"error"`
+	Files          []string      `json:"files"`
+	Methods        []*MethodView `json:"methods"`
+}
+
+// MethodView 方法描述符的展示数据
+type MethodView struct {
+	Method          string `json:"method"`
+	Input           string `json:"input"`
+	Output          string `json:"output"`
+	ClientStreaming bool   `json:"clientStreaming"`
+	ServerStreaming bool   `json:"serverStreaming"`
+}
+
+// descriptors of proxies
+var proxyDescriptors = make(map[string]*ProxyDescriptors)
+
+// descriptors lock
+var descriptorsLock sync.RWMutex
+
+// listeners called after descriptors of proxy changed
+var descriptorsListeners []func(proxyName string, descriptors *ProxyDescriptors)
+
+// add listener of descriptors update
+func onDescriptorsUpdate(listener func(proxyName string, descriptors *ProxyDescriptors)) {
+	descriptorsListeners = append(descriptorsListeners, listener)
+}
+
+// init descriptors source of proxy, server reflection is used if no descriptor set configured
+func initProxyDescriptors(proxyName string, conf map[string]interface{}) error {
+	descriptors := &ProxyDescriptors{
+		proxyName:  proxyName,
+		reflection: true,
+		interval:   DescriptorRefreshInterval,
+		files:      &protoregistry.Files{},
+		types:      &protoregistry.Types{},
+		methods:    make(map[string]protoreflect.MethodDescriptor),
+	}
+	if conf != nil {
+		paths, ok := configStringList(conf, "DESCRIPTOR_SET")
+		if !ok {
+			return ErrDescriptorsInvalid
+		}
+		descriptors.descriptorSets = paths
+		if path, ok := conf["DESCRIPTOR_SET"].(string); ok && path != "" {
+			descriptors.descriptorSets = []string{path}
+		}
+		descriptors.reflection = configBool(conf, "REFLECTION", len(descriptors.descriptorSets) == 0)
+		if interval := configInt(conf, "REFRESH_INTERVAL", 0); interval > 0 {
+			descriptors.interval = time.Duration(interval) * time.Second
+		}
+	}
+	descriptorsLock.Lock()
+	defer descriptorsLock.Unlock()
+	proxyDescriptors[proxyName] = descriptors
+	return nil
+}
+
+// get descriptors of proxy
+func getProxyDescriptors(proxyName string) (*ProxyDescriptors, bool) {
+	descriptorsLock.RLock()
+	defer descriptorsLock.RUnlock()
+	descriptors, ok := proxyDescriptors[proxyName]
+	return descriptors, ok
+}
+
+// remove descriptors of proxy
+func removeProxyDescriptors(proxyName string) {
+	descriptorsLock.Lock()
+	defer descriptorsLock.Unlock()
+	delete(proxyDescriptors, proxyName)
+}
+
+// keep descriptors of proxy loaded, refreshed every interval
+func watchProxyDescriptors(proxyName string) {
+	descriptors, ok := getProxyDescriptors(proxyName)
+	if !ok {
+		return
+	}
+	descriptors.watchOnce.Do(func() {
+		go descriptors.watch()
+	})
+}
+
+// refresh loop until the proxy is removed, retry soon until the first success
+func (d *ProxyDescriptors) watch() {
+	for {
+		if current, ok := getProxyDescriptors(d.proxyName); !ok || current != d {
+			return
+		}
+		wait := d.interval
+		if err := d.Refresh(); err != nil && d.UpdatedAt().IsZero() && wait > 10*time.Second {
+			wait = 10 * time.Second
+		}
+		select {
+		case <-discoveryCtx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// load descriptors if never loaded or expired
+func (d *ProxyDescriptors) load() {
+	if time.Since(d.UpdatedAt()) > d.interval {
+		d.Refresh()
+	}
+}
+
+// refresh descriptors from sources, listeners are called if changed
+func (d *ProxyDescriptors) Refresh() error {
+	d.refresh.Lock()
+	defer d.refresh.Unlock()
+	protos, err := d.fetch()
+	if err != nil {
+		logging.ERROR.Error("load descriptors error, proxy ", d.proxyName, ": ", err)
+		d.lock.Lock()
+		d.err = err
+		d.lock.Unlock()
+		return err
+	}
+	hash := descriptorsHash(protos)
+	d.lock.Lock()
+	changed := hash != d.hash || d.updatedAt.IsZero()
+	if changed {
+		files := buildFiles(protos)
+		d.protos = protos
+		d.files = files
+		d.types = descriptorTypes(files)
+		d.methods = descriptorMethods(files)
+		d.hash = hash
+	}
+	d.updatedAt = time.Now()
+	d.err = nil
+	methods := len(d.methods)
+	d.lock.Unlock()
+	if changed {
+		logging.Log.Info("proxy ", d.proxyName, " descriptors loaded, ", len(protos), " files ", methods, " methods")
+		for _, listener := range descriptorsListeners {
+			listener(d.proxyName, d)
+		}
+	}
+	return nil
+}
+
+// fetch file descriptor protos of all sources
+func (d *ProxyDescriptors) fetch() (map[string]*descriptorpb.FileDescriptorProto, error) {
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, path := range d.descriptorSets {
+		files, err := loadDescriptorSet(path)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor set %s: %v", path, err)
+		}
+		for name, file := range files {
+			protos[name] = file
+		}
+	}
+	if d.reflection {
+		files, err := proxyReflectionFileProtos(d.proxyName)
+		if err != nil {
+			return nil, err
+		}
+		// descriptor set files take precedence
+		for name, file := range files {
+			if _, ok := protos[name]; !ok {
+				protos[name] = file
+			}
+		}
+	}
+	return protos, nil
+}
+
+// fetch file descriptor protos from an available endpoint of proxy
+func proxyReflectionFileProtos(proxyName string) (map[string]*descriptorpb.FileDescriptorProto, error) {
+	pools, _ := routePools(proxyName, metadata.MD{})
+	err := fmt.Errorf("no endpoint available for proxy %s", proxyName)
+	for _, pool := range pools {
+		var files map[string]*descriptorpb.FileDescriptorProto
+		ctx, cancel := context.WithTimeout(discoveryCtx, ReflectionTimeout)
+		files, err = reflectionFileProtos(ctx, pool.poolRemoteAddr)
+		cancel()
+		if err == nil {
+			return files, nil
+		}
+	}
+	return nil, err
+}
+
+// last successful load time
+func (d *ProxyDescriptors) UpdatedAt() time.Time {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.updatedAt
+}
+
+// files and types of descriptors
+func (d *ProxyDescriptors) Files() (*protoregistry.Files, *protoregistry.Types) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.files, d.types
+}
+
+// file descriptor protos
+func (d *ProxyDescriptors) Protos() map[string]*descriptorpb.FileDescriptorProto {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.protos
+}
+
+// method descriptors, key is /service/method
+func (d *ProxyDescriptors) Methods() map[string]protoreflect.MethodDescriptor {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.methods
+}
+
+// resolve method descriptor
+func (d *ProxyDescriptors) Method(fullMethodName string) (protoreflect.MethodDescriptor, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	method, ok := d.methods[fullMethodName]
+	return method, ok
+}
+
+// view of descriptors
+func (d *ProxyDescriptors) View() *DescriptorsView {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	view := &DescriptorsView{
+		Proxy:          d.proxyName,
+		DescriptorSets: append([]string{}, d.descriptorSets...),
+		Reflection:     d.reflection,
+		Files:          []string{},
+		Methods:        []*MethodView{},
+	}
+	if !d.updatedAt.IsZero() {
+		view.UpdatedAt = d.updatedAt.Format(time.RFC3339)
+	}
+	if d.err != nil {
+		view.Error = d.err.Error()
+	}
+	for name := range d.protos {
+		view.Files = append(view.Files, name)
+	}
+	sort.Strings(view.Files)
+	for name, method := range d.methods {
+		view.Methods = append(view.Methods, &MethodView{
+			Method:          name,
+			Input:           string(method.Input().FullName()),
+			Output:          string(method.Output().FullName()),
+			ClientStreaming: method.IsStreamingClient(),
+			ServerStreaming: method.IsStreamingServer(),
+		})
+	}
+	sort.Slice(view.Methods, func(i, j int) bool { return view.Methods[i].Method < view.Methods[j].Method })
+	return view
+}
+
+// methods of files, key is /service/method
+func descriptorMethods(files *protoregistry.Files) map[string]protoreflect.MethodDescriptor {
+	methods := make(map[string]protoreflect.MethodDescriptor)
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			service := file.Services().Get(i)
+			for j := 0; j < service.Methods().Len(); j++ {
+				method := service.Methods().Get(j)
+				methods["/"+string(service.FullName())+"/"+string(method.Name())] = method
+			}
+		}
+		return true
+	})
+	return methods
+}
+
+// hash of file descriptor protos
+func descriptorsHash(protos map[string]*descriptorpb.FileDescriptorProto) uint64 {
+	names := make([]string, 0, len(protos))
+	for name := range protos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := fnv.New64a()
+	for _, name := range names {
+		data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(protos[name])
+		h.Write(data)
+	}
+	return h.Sum64()
+}
+
+// resolve method descriptor of proxy, descriptors are loaded if expired
+func resolveMethod(proxyName, fullMethodName string) (protoreflect.MethodDescriptor, error) {
+	descriptors, ok := getProxyDescriptors(proxyName)
+	if !ok {
+		return nil, ErrDescriptorsNotFound
+	}
+	method, ok := descriptors.Method(fullMethodName)
+	if !ok {
+		return nil, ErrMethodNotFound
+	}
+	return method, nil
+}
+
+// decode request frame of method
+func decodeRequestFrame(method protoreflect.MethodDescriptor, payload []byte) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(method.Input())
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// get descriptors views of all proxies
+func GetDescriptors() []*DescriptorsView {
+	descriptorsLock.RLock()
+	proxies := make([]*ProxyDescriptors, 0, len(proxyDescriptors))
+	for _, descriptors := range proxyDescriptors {
+		proxies = append(proxies, descriptors)
+	}
+	descriptorsLock.RUnlock()
+	views := []*DescriptorsView{}
+	for _, descriptors := range proxies {
+		views = append(views, descriptors.View())
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Proxy < views[j].Proxy })
+	return views
+}
+
+// refresh descriptors of proxy
+func RefreshDescriptors(proxyName string) error {
+	descriptors, ok := getProxyDescriptors(proxyName)
+	if !ok {
+		return ErrDescriptorsNotFound
+	}
+	return descriptors.Refresh()
+}
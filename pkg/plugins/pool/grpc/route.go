@@ -0,0 +1,268 @@
+package grpc
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// metadata match type
+const (
+	MATCH_EXACT   = "exact"
+	MATCH_PREFIX  = "prefix"
+	MATCH_REGEX   = "regex"
+	MATCH_PRESENT = "present"
+	MATCH_ABSENT  = "absent"
+)
+
+// route field fallback
+const (
+	FIELD_FALLBACK_DEFAULT = "DEFAULT"
+	FIELD_FALLBACK_REJECT  = "REJECT"
+)
+
+// metadata key prefix of request fields, not a valid metadata key so it can not be set by clients
+const FIELD_KEY_PREFIX = ":field:"
+
+// route config invalid
+var ErrRouteInvalid = errors.New("route config invalid")
+
+// MetadataMatch 请求 metadata 匹配条件, Field 不为空时匹配请求消息中的字段
+type MetadataMatch struct {
+	Key   string
+	Field string
+	Type  string
+	Value string
+	regex *regexp.Regexp
+}
+
+// RouteRule 路由规则, 所有 match 均满足时路由到 subset
+type RouteRule struct {
+	Name   string
+	Match  []*MetadataMatch
+	Subset map[string]string
+}
+
+// RouteTable proxy 的路由表, 按顺序匹配, 未命中时使用默认 subset
+type RouteTable struct {
+	Rules         []*RouteRule
+	DefaultSubset map[string]string
+	HashKey       string        // 按 metadata 或字段值一致性哈希选择 endpoint
+	FieldFallback string        // 第一条请求消息无法解析出字段时的处理
+	FieldWait     time.Duration // 等待第一条请求消息的时间
+	Fields        []string      // 路由使用的请求字段
+}
+
+// new metadata match
+func NewMetadataMatch(key, matchType, value string) (*MetadataMatch, error) {
+	m := &MetadataMatch{
+		Key:   strings.ToLower(key),
+		Type:  strings.ToLower(matchType),
+		Value: value,
+	}
+	if m.Key == "" {
+		return nil, ErrRouteInvalid
+	}
+	switch m.Type {
+	case MATCH_EXACT, MATCH_PREFIX, MATCH_PRESENT, MATCH_ABSENT:
+	case MATCH_REGEX:
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		m.regex = regex
+	default:
+		return nil, ErrRouteInvalid
+	}
+	return m, nil
+}
+
+// match request metadata
+func (m *MetadataMatch) Matches(md metadata.MD) bool {
+	values := md.Get(m.Key)
+	switch m.Type {
+	case MATCH_PRESENT:
+		return len(values) > 0
+	case MATCH_ABSENT:
+		return len(values) == 0
+	}
+	for _, v := range values {
+		switch m.Type {
+		case MATCH_EXACT:
+			if v == m.Value {
+				return true
+			}
+		case MATCH_PREFIX:
+			if strings.HasPrefix(v, m.Value) {
+				return true
+			}
+		case MATCH_REGEX:
+			if m.regex.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// match all conditions
+func (rule *RouteRule) Matches(md metadata.MD) bool {
+	for _, m := range rule.Match {
+		if !m.Matches(md) {
+			return false
+		}
+	}
+	return true
+}
+
+// select subset by rules in order
+func (table *RouteTable) SelectSubset(md metadata.MD) map[string]string {
+	for _, rule := range table.Rules {
+		if rule.Matches(md) {
+			return rule.Subset
+		}
+	}
+	return table.DefaultSubset
+}
+
+// whether route table uses fields of request message
+func (table *RouteTable) needsFields() bool {
+	return len(table.Fields) > 0
+}
+
+// hash value of request, empty if not set
+func (table *RouteTable) hashValue(md metadata.MD) string {
+	if table.HashKey == "" {
+		return ""
+	}
+	if values := md.Get(table.HashKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// select pool by weighted rendezvous hashing, the same key goes to the same endpoint while it is available
+func hashPool(pools map[string]*Pool, key string) *Pool {
+	var selected *Pool
+	maxScore := math.Inf(-1)
+	connLock.RLock()
+	defer connLock.RUnlock()
+	for _, pool := range pools {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(pool.poolRemoteAddr))
+		// uniform in (0, 1)
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		weight := float64(pool.weight)
+		if weight <= 0 {
+			weight = 1
+		}
+		if score := -weight / math.Log(u); score > maxScore {
+			selected, maxScore = pool, score
+		}
+	}
+	return selected
+}
+
+// filter pools by route table
+func (table *RouteTable) filterPools(pools map[string]*Pool, md metadata.MD) map[string]*Pool {
+	subset := table.SelectSubset(md)
+	selected := poolsWithLabels(pools, subset)
+	if len(selected) > 0 {
+		return selected
+	}
+	// fall back to default subset
+	if len(table.DefaultSubset) > 0 {
+		return poolsWithLabels(pools, table.DefaultSubset)
+	}
+	return pools
+}
+
+// get pools match subset labels
+func poolsWithLabels(pools map[string]*Pool, subset map[string]string) map[string]*Pool {
+	if len(subset) == 0 {
+		return pools
+	}
+	selected := make(map[string]*Pool)
+	for k, pool := range pools {
+		if matchLabels(pool.labels, subset) {
+			selected[k] = pool
+		}
+	}
+	return selected
+}
+
+// parse route table from proxy config
+func parseRouteTable(proxyMap map[string]interface{}) (*RouteTable, error) {
+	table := &RouteTable{
+		DefaultSubset: configStringMap(proxyMap, "DEFAULT_SUBSET"),
+		FieldFallback: strings.ToUpper(configString(proxyMap, "ROUTE_FIELD_FALLBACK", FIELD_FALLBACK_DEFAULT)),
+		FieldWait:     time.Duration(configInt(proxyMap, "ROUTE_FIELD_WAIT", 1000)) * time.Millisecond,
+	}
+	if table.FieldFallback != FIELD_FALLBACK_DEFAULT && table.FieldFallback != FIELD_FALLBACK_REJECT {
+		return nil, ErrRouteInvalid
+	}
+	if hashMap := configMap(proxyMap, "ROUTE_HASH"); hashMap != nil {
+		if field := configString(hashMap, "FIELD", ""); field != "" {
+			table.HashKey = FIELD_KEY_PREFIX + strings.ToLower(field)
+			table.Fields = append(table.Fields, field)
+		} else if key := configString(hashMap, "KEY", ""); key != "" {
+			table.HashKey = strings.ToLower(key)
+		} else {
+			return nil, ErrRouteInvalid
+		}
+	}
+	for _, v := range configList(proxyMap, "ROUTE_RULES") {
+		ruleMap, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, ErrRouteInvalid
+		}
+		matches, err := parseMetadataMatches(configList(ruleMap, "MATCH"))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if m.Field != "" {
+				table.Fields = append(table.Fields, m.Field)
+			}
+		}
+		table.Rules = append(table.Rules, &RouteRule{
+			Name:   configString(ruleMap, "NAME", ""),
+			Match:  matches,
+			Subset: configStringMap(ruleMap, "SUBSET"),
+		})
+	}
+	return table, nil
+}
+
+// parse metadata matches
+func parseMetadataMatches(list []interface{}) ([]*MetadataMatch, error) {
+	var matches []*MetadataMatch
+	for _, v := range list {
+		matchMap, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, ErrRouteInvalid
+		}
+		key := configString(matchMap, "KEY", "")
+		field := configString(matchMap, "FIELD", "")
+		if field != "" {
+			key = FIELD_KEY_PREFIX + field
+		}
+		m, err := NewMetadataMatch(
+			key,
+			configString(matchMap, "TYPE", MATCH_EXACT),
+			configString(matchMap, "VALUE", ""),
+		)
+		if err != nil {
+			return nil, err
+		}
+		m.Field = field
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
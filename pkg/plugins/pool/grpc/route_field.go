@@ -0,0 +1,162 @@
+package grpc
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// context key of request field values
+type routeFieldsKey struct{}
+
+// received frame of server stream
+type recvResult struct {
+	frame *frame
+	err   error
+}
+
+// route table of proxy
+func proxyRouteTable(proxyName string) *RouteTable {
+	connLock.RLock()
+	defer connLock.RUnlock()
+	table, _ := connProxy[proxyName]["routeTable"].(*RouteTable)
+	return table
+}
+
+// read the first request frame and put the route fields into context,
+// the frame is delivered by the returned channel, nil if route table uses no fields
+func routeByFields(ctx context.Context, serverStream grpc.ServerStream, fullMethodName string) (context.Context, <-chan recvResult, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok {
+		return ctx, nil, nil
+	}
+	table := proxyRouteTable(proxyName)
+	if table == nil || !table.needsFields() {
+		return ctx, nil, nil
+	}
+
+	first := make(chan recvResult, 1)
+	go func() {
+		f := &frame{}
+		err := serverStream.RecvMsg(f)
+		first <- recvResult{frame: f, err: err}
+	}()
+	var fields map[string]string
+	var err error
+	select {
+	case result := <-first:
+		// deliver the frame again to forwarding
+		first <- result
+		if result.err != nil {
+			err = fmt.Errorf("no request message: %v", result.err)
+			break
+		}
+		fields, err = requestFields(proxyName, fullMethodName, result.frame.payload, table.Fields)
+	case <-time.After(table.FieldWait):
+		err = fmt.Errorf("no request message in %v", table.FieldWait)
+	case <-ctx.Done():
+		return ctx, first, status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil {
+		if table.FieldFallback == FIELD_FALLBACK_REJECT {
+			return ctx, first, status.Errorf(codes.InvalidArgument, "route fields of %s: %v", fullMethodName, err)
+		}
+		logging.DEBUG.Debug("route fields of ", fullMethodName, " fall back to default: ", err)
+		return ctx, first, nil
+	}
+	return context.WithValue(ctx, routeFieldsKey{}, fields), first, nil
+}
+
+// decode request frame and get values of field paths, missing fields are not set
+func requestFields(proxyName, fullMethodName string, payload []byte, paths []string) (map[string]string, error) {
+	method, err := resolveMethod(proxyName, fullMethodName)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := decodeRequestFrame(method, payload)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	for _, path := range paths {
+		if value, ok := messageFieldValue(msg, path); ok {
+			fields[path] = value
+		}
+	}
+	return fields, nil
+}
+
+// get value of field path as string, e.g. tenant.id
+func messageFieldValue(msg protoreflect.Message, path string) (string, bool) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		field := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			field = msg.Descriptor().Fields().ByJSONName(name)
+		}
+		if field == nil || field.IsList() || field.IsMap() || !msg.Has(field) {
+			return "", false
+		}
+		value := msg.Get(field)
+		if i < len(names)-1 {
+			if field.Kind() != protoreflect.MessageKind {
+				return "", false
+			}
+			msg = value.Message()
+			continue
+		}
+		switch field.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			return "", false
+		case protoreflect.EnumKind:
+			if enumValue := field.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+				return string(enumValue.Name()), true
+			}
+		case protoreflect.BytesKind:
+			return base64.StdEncoding.EncodeToString(value.Bytes()), true
+		}
+		return value.String(), true
+	}
+	return "", false
+}
+
+// metadata with route field values, used to select pools only
+func routeMetadata(ctx context.Context, md metadata.MD) metadata.MD {
+	fields, ok := ctx.Value(routeFieldsKey{}).(map[string]string)
+	routeMd := md.Copy()
+	// field keys can only come from request message
+	for k := range routeMd {
+		if strings.HasPrefix(k, FIELD_KEY_PREFIX) {
+			delete(routeMd, k)
+		}
+	}
+	if ok {
+		for path, value := range fields {
+			routeMd.Set(FIELD_KEY_PREFIX+path, value)
+		}
+	}
+	return routeMd
+}
+
+// receive frame, the first frame from channel if read before routing
+func recvFrame(serverStream grpc.ServerStream, first *<-chan recvResult, f *frame) error {
+	if *first != nil {
+		result := <-*first
+		*first = nil
+		if result.err == nil {
+			f.payload = result.frame.payload
+		}
+		return result.err
+	}
+	return serverStream.RecvMsg(f)
+}
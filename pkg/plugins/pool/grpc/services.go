@@ -0,0 +1,333 @@
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// unknown method action
+const (
+	UNKNOWN_METHOD_PASS   = "PASS"
+	UNKNOWN_METHOD_REJECT = "REJECT"
+)
+
+// services config invalid
+var ErrServicesInvalid = errors.New("services config invalid")
+
+// MethodPolicy 方法的调用策略, 方法未配置时继承服务的策略
+type MethodPolicy struct {
+	Timeout      time.Duration
+	Retry        int
+	RetryOn      []codes.Code
+	RetryBackoff time.Duration
+	Auth         *MethodAuth
+	Cache        *MethodCache
+	Coalesce     *MethodCoalesce
+	Idempotency  *MethodIdempotency
+	Limits       *MessageLimits
+}
+
+// MethodAuth 方法的鉴权, metadata 的值需要等于 VALUES 之一
+type MethodAuth struct {
+	Metadata string
+	Values   []string
+}
+
+// ProxyServices proxy 声明和自动发现的服务、方法
+type ProxyServices struct {
+	UnknownMethod string
+	AutoDiscover  bool
+	services      map[string]*MethodPolicy // 服务的默认策略, key 为服务全名
+	declared      map[string]bool          // 配置了 METHODS 的服务
+	methods       map[string]*MethodPolicy // key 为 /service/method
+	lock          sync.RWMutex
+	discovered    map[string]bool // 自动发现的方法, value 为是否 client streaming
+	cache         responseCache   // 方法的响应缓存
+}
+
+// parse services from proxy config
+func parseProxyServices(proxyMap map[string]interface{}) (*ProxyServices, error) {
+	conf := configMap(proxyMap, "SERVICES")
+	if conf == nil {
+		return nil, nil
+	}
+	services := &ProxyServices{
+		UnknownMethod: strings.ToUpper(configString(conf, "UNKNOWN_METHOD", UNKNOWN_METHOD_PASS)),
+		AutoDiscover:  configBool(conf, "AUTO_DISCOVER", false),
+		services:      make(map[string]*MethodPolicy),
+		declared:      make(map[string]bool),
+		methods:       make(map[string]*MethodPolicy),
+	}
+	if services.UnknownMethod != UNKNOWN_METHOD_PASS && services.UnknownMethod != UNKNOWN_METHOD_REJECT {
+		return nil, ErrServicesInvalid
+	}
+	for _, v := range configList(conf, "LIST") {
+		serviceMap, ok := v.(map[string]interface{})
+		serviceName := configString(serviceMap, "NAME", "")
+		if !ok || serviceName == "" {
+			return nil, ErrServicesInvalid
+		}
+		servicePolicy, err := parseMethodPolicy(serviceMap, &MethodPolicy{})
+		if err != nil {
+			return nil, err
+		}
+		services.services[serviceName] = servicePolicy
+		for _, m := range configList(serviceMap, "METHODS") {
+			var methodMap map[string]interface{}
+			switch method := m.(type) {
+			case string:
+				methodMap = map[string]interface{}{"NAME": method}
+			case map[string]interface{}:
+				methodMap = method
+			}
+			methodName := configString(methodMap, "NAME", "")
+			if methodName == "" {
+				return nil, ErrServicesInvalid
+			}
+			methodPolicy, err := parseMethodPolicy(methodMap, servicePolicy)
+			if err != nil {
+				return nil, err
+			}
+			services.declared[serviceName] = true
+			services.methods["/"+serviceName+"/"+methodName] = methodPolicy
+		}
+	}
+	// response cache store shared by cached methods
+	for _, policy := range services.policies() {
+		if policy.Cache == nil {
+			continue
+		}
+		cache, err := newResponseCache(configString(proxyMap, "PROXY_NAME", ""),
+			configString(conf, "CACHE_BACKEND", CACHE_BACKEND_MEMORY), configInt(conf, "CACHE_MAX_ENTRIES", 10000))
+		if err != nil {
+			return nil, err
+		}
+		services.cache = cache
+		break
+	}
+	return services, nil
+}
+
+// policies of services and methods
+func (services *ProxyServices) policies() []*MethodPolicy {
+	var policies []*MethodPolicy
+	for _, policy := range services.services {
+		policies = append(policies, policy)
+	}
+	for _, policy := range services.methods {
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// parse method policy, fields not set inherit from parent
+func parseMethodPolicy(conf map[string]interface{}, parent *MethodPolicy) (*MethodPolicy, error) {
+	policy := *parent
+	if timeout := configInt(conf, "TIMEOUT", 0); timeout > 0 {
+		policy.Timeout = time.Duration(timeout) * time.Millisecond
+	}
+	policy.Retry = configInt(conf, "RETRY", policy.Retry)
+	policy.RetryBackoff = time.Duration(configInt(conf, "RETRY_BACKOFF", int(policy.RetryBackoff/time.Millisecond))) * time.Millisecond
+	if retryOn, ok := configStringList(conf, "RETRY_ON"); !ok {
+		return nil, ErrServicesInvalid
+	} else if len(retryOn) > 0 {
+		policy.RetryOn = nil
+		for _, c := range retryOn {
+			code, err := parseStatusCode(c)
+			if err != nil {
+				return nil, err
+			}
+			policy.RetryOn = append(policy.RetryOn, code)
+		}
+	}
+	if policy.Retry > 0 && len(policy.RetryOn) == 0 {
+		policy.RetryOn = []codes.Code{codes.Unavailable}
+	}
+	if authMap := configMap(conf, "AUTH"); authMap != nil {
+		auth := &MethodAuth{Metadata: strings.ToLower(configString(authMap, "METADATA", "authorization"))}
+		values, ok := configStringList(authMap, "VALUES")
+		if !ok || len(values) == 0 {
+			return nil, ErrServicesInvalid
+		}
+		auth.Values = values
+		policy.Auth = auth
+	}
+	if cacheMap := configMap(conf, "CACHE"); cacheMap != nil {
+		cache := &MethodCache{TTL: time.Duration(configInt(cacheMap, "TTL", 0)) * time.Millisecond}
+		for _, key := range configList(cacheMap, "METADATA") {
+			cache.Metadata = append(cache.Metadata, strings.ToLower(fmt.Sprintf("%v", key)))
+		}
+		if cache.TTL <= 0 {
+			return nil, ErrServicesInvalid
+		}
+		policy.Cache = cache
+	}
+	switch coalesce := conf["COALESCE"].(type) {
+	case bool:
+		policy.Coalesce = nil
+		if coalesce {
+			policy.Coalesce = &MethodCoalesce{}
+		}
+	case map[string]interface{}:
+		policy.Coalesce = &MethodCoalesce{Timeout: time.Duration(configInt(coalesce, "TIMEOUT", 0)) * time.Millisecond}
+		for _, key := range configList(coalesce, "METADATA") {
+			policy.Coalesce.Metadata = append(policy.Coalesce.Metadata, strings.ToLower(fmt.Sprintf("%v", key)))
+		}
+	}
+	if idempotencyMap := configMap(conf, "IDEMPOTENCY"); idempotencyMap != nil {
+		idempotency := &MethodIdempotency{
+			Metadata:   strings.ToLower(configString(idempotencyMap, "METADATA", "idempotency-key")),
+			TTL:        time.Duration(configInt(idempotencyMap, "TTL", 86400000)) * time.Millisecond,
+			PendingTTL: time.Duration(configInt(idempotencyMap, "PENDING_TTL", 60000)) * time.Millisecond,
+			Concurrent: strings.ToUpper(configString(idempotencyMap, "CONCURRENT", IDEMPOTENCY_WAIT)),
+		}
+		if idempotency.TTL <= 0 || idempotency.PendingTTL <= 0 ||
+			(idempotency.Concurrent != IDEMPOTENCY_WAIT && idempotency.Concurrent != IDEMPOTENCY_REJECT) {
+			return nil, ErrServicesInvalid
+		}
+		if cacheRedis == nil {
+			logging.ERROR.Error("idempotency of method uses redis, but redis cache is not enabled")
+		}
+		policy.Idempotency = idempotency
+	}
+	if limitsMap := configMap(conf, "LIMITS"); limitsMap != nil {
+		policy.Limits = parseMessageLimits(limitsMap, policy.Limits)
+	}
+	return &policy, nil
+}
+
+func init() {
+	onDescriptorsUpdate(updateDiscoveredMethods)
+}
+
+// auto discover methods of proxy from the descriptors registry
+func startServiceDiscovery(proxyName string) {
+	if descriptors, ok := getProxyDescriptors(proxyName); ok && !descriptors.UpdatedAt().IsZero() {
+		updateDiscoveredMethods(proxyName, descriptors)
+	}
+	watchProxyDescriptors(proxyName)
+}
+
+// update discovered methods after descriptors changed
+func updateDiscoveredMethods(proxyName string, descriptors *ProxyDescriptors) {
+	connLock.RLock()
+	services, _ := connProxy[proxyName]["services"].(*ProxyServices)
+	connLock.RUnlock()
+	if services == nil || !services.AutoDiscover {
+		return
+	}
+	discovered := make(map[string]bool)
+	for name, method := range descriptors.Methods() {
+		discovered[name] = method.IsStreamingClient()
+	}
+	services.lock.Lock()
+	services.discovered = discovered
+	services.lock.Unlock()
+	logging.Log.Info("proxy ", proxyName, " discovered ", len(discovered), " methods")
+}
+
+// get policy of method, false if method is unknown
+func (services *ProxyServices) lookup(fullMethodName string) (*MethodPolicy, bool) {
+	if policy, ok := services.methods[fullMethodName]; ok {
+		return policy, true
+	}
+	serviceName := strings.SplitN(strings.TrimPrefix(fullMethodName, "/"), "/", 2)[0]
+	servicePolicy, serviceOk := services.services[serviceName]
+	services.lock.RLock()
+	_, discovered := services.discovered[fullMethodName]
+	services.lock.RUnlock()
+	if serviceOk && (!services.declared[serviceName] || discovered) {
+		return servicePolicy, true
+	}
+	if discovered {
+		return &MethodPolicy{}, true
+	}
+	return nil, false
+}
+
+// whether method is known by descriptors as unary or server streaming
+func retryableMethod(proxyName, fullMethodName string) bool {
+	method, err := resolveMethod(proxyName, fullMethodName)
+	return err == nil && !method.IsStreamingClient()
+}
+
+// get method policy of the call, error if the method is rejected
+func matchMethodPolicy(ctx context.Context, fullMethodName string) (*MethodPolicy, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	proxyName, ok := resolveProxyName(fullMethodName, md)
+	if !ok {
+		return nil, nil
+	}
+	connLock.RLock()
+	services, _ := connProxy[proxyName]["services"].(*ProxyServices)
+	connLock.RUnlock()
+	if services == nil {
+		return nil, nil
+	}
+	policy, known := services.lookup(fullMethodName)
+	if !known {
+		if services.UnknownMethod == UNKNOWN_METHOD_REJECT {
+			return nil, status.Errorf(codes.Unimplemented, "unknown method %s", fullMethodName)
+		}
+		return nil, nil
+	}
+	if err := policy.authorize(md); err != nil {
+		return nil, err
+	}
+	// retry replays buffered requests, only for unary and server streaming
+	if policy.Retry > 0 && !retryableMethod(proxyName, fullMethodName) {
+		noRetry := *policy
+		noRetry.Retry = 0
+		policy = &noRetry
+	}
+	return policy, nil
+}
+
+// check auth metadata
+func (policy *MethodPolicy) authorize(md metadata.MD) error {
+	if policy == nil || policy.Auth == nil {
+		return nil
+	}
+	for _, value := range md.Get(policy.Auth.Metadata) {
+		for _, allowed := range policy.Auth.Values {
+			if subtle.ConstantTimeCompare([]byte(value), []byte(allowed)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Errorf(codes.Unauthenticated, "invalid %s", policy.Auth.Metadata)
+}
+
+// call context with method timeout
+func (policy *MethodPolicy) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if policy == nil || policy.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, policy.Timeout)
+}
+
+// whether the call should be retried
+func (policy *MethodPolicy) retryable() bool {
+	return policy != nil && policy.Retry > 0
+}
+
+// whether error should be retried
+func (policy *MethodPolicy) retryOn(err error) bool {
+	code := status.Code(err)
+	for _, c := range policy.RetryOn {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
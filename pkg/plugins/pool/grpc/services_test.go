@@ -0,0 +1,74 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+func TestParseMethodPolicyScalars(t *testing.T) {
+	policy, err := parseMethodPolicy(map[string]interface{}{
+		"RETRY":    2,
+		"RETRY_ON": []interface{}{14, "DEADLINE_EXCEEDED"},
+		"AUTH":     map[string]interface{}{"VALUES": []interface{}{12345}},
+	}, &MethodPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.RetryOn) != 2 || policy.RetryOn[0] != codes.Unavailable || policy.RetryOn[1] != codes.DeadlineExceeded {
+		t.Errorf("retry on %v", policy.RetryOn)
+	}
+	if policy.Auth == nil || len(policy.Auth.Values) != 1 || policy.Auth.Values[0] != "12345" {
+		t.Errorf("auth %+v", policy.Auth)
+	}
+
+	for _, conf := range []map[string]interface{}{
+		{"RETRY_ON": []interface{}{[]interface{}{14}}},
+		{"AUTH": map[string]interface{}{"VALUES": []interface{}{map[string]interface{}{"TOKEN": "x"}}}},
+		{"AUTH": map[string]interface{}{"VALUES": []interface{}{}}},
+	} {
+		if _, err := parseMethodPolicy(conf, &MethodPolicy{}); err != ErrServicesInvalid {
+			t.Errorf("conf %v error %v, want %v", conf, err, ErrServicesInvalid)
+		}
+	}
+}
+
+func TestMethodPolicyRetryByDescriptors(t *testing.T) {
+	proxyMap := map[string]interface{}{
+		"SERVICES": map[string]interface{}{
+			"LIST": []interface{}{
+				map[string]interface{}{"NAME": "grpc.health.v1.Health", "RETRY": 2},
+				map[string]interface{}{"NAME": "grpc.reflection.v1alpha.ServerReflection", "RETRY": 2},
+				map[string]interface{}{"NAME": "hello.Greeter", "RETRY": 2},
+			},
+		},
+	}
+	services, err := parseProxyServices(proxyMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProxy(t, "retry", map[string]interface{}{"services": services})
+	testDescriptors(t, "retry", healthpb.File_grpc_health_v1_health_proto, rpb.File_grpc_reflection_v1alpha_reflection_proto)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("proxy", "retry"))
+	for method, want := range map[string]int{
+		"/grpc.health.v1.Health/Check": 2,
+		"/grpc.health.v1.Health/Watch": 2,
+		// client streaming
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": 0,
+		// not in descriptors
+		"/hello.Greeter/SayHello": 0,
+	} {
+		policy, err := matchMethodPolicy(ctx, method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy.Retry != want {
+			t.Errorf("retry of %s %d, want %d", method, policy.Retry, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package grpc
+
+import (
+	logging "synapsor/pkg/core/log"
+	"sync/atomic"
+)
+
+// readiness of synapsor, 1 if ready
+var ready int32
+
+// set readiness, ready after pools initialized, not ready before shutdown
+func SetReady(r bool) {
+	value := int32(0)
+	if r {
+		value = 1
+	}
+	atomic.StoreInt32(&ready, value)
+}
+
+// whether synapsor is ready
+func Ready() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
+// stop discovery and close all pools, called after proxy servers stopped
+func ClosePools() {
+	stopDiscovery()
+	connLock.Lock()
+	var pools []*Pool
+	for proxyName, proxyPools := range connPools {
+		for _, pool := range proxyPools {
+			pools = append(pools, pool)
+		}
+		delete(connPools, proxyName)
+	}
+	connLock.Unlock()
+	for _, pool := range pools {
+		pool.CloseWait()
+	}
+	logging.Log.Info("close ", len(pools), " pools finish")
+}
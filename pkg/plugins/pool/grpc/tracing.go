@@ -0,0 +1,197 @@
+package grpc
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	tracingOnce       sync.Once
+	tracer            trace.Tracer
+	tracerProvider    *sdktrace.TracerProvider
+	tracingPropagator propagation.TextMapPropagator
+)
+
+// metadata carrier of trace context
+type metadataCarrier metadata.MD
+
+// get first value of key
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// set value of key
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// keys of carrier
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// get tracing config string, env overrides config file
+func tracingConfig(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		value = viper.GetString(key)
+	}
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// init tracer and otlp exporter, tracing is disabled if not enabled or exporter fails
+func loadTracing() {
+	if enabled, _ := strconv.ParseBool(tracingConfig("TRACING_ENABLED", "false")); !enabled {
+		return
+	}
+	var propagators []propagation.TextMapPropagator
+	for _, name := range strings.Split(tracingConfig("TRACING_PROPAGATORS", "tracecontext,b3"), ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{}, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader|b3.B3MultipleHeader)))
+		}
+	}
+	tracingPropagator = propagation.NewCompositeTextMapPropagator(propagators...)
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(tracingConfig("TRACING_OTLP_ENDPOINT", "localhost:4317"))}
+	if insecure, _ := strconv.ParseBool(tracingConfig("TRACING_OTLP_INSECURE", "true")); insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		logging.ERROR.Error("create otlp trace exporter: ", err)
+		return
+	}
+	sampleRate, err := strconv.ParseFloat(tracingConfig("TRACING_SAMPLE_RATE", "1"), 64)
+	if err != nil {
+		sampleRate = 1
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRate))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(tracingConfig("TRACING_SERVICE_NAME", "synapsor")))),
+	)
+	tracer = tracerProvider.Tracer("synapsor/pkg/plugins/pool/grpc")
+	logging.Log.Info("tracing export to ", tracingConfig("TRACING_OTLP_ENDPOINT", "localhost:4317"))
+}
+
+// whether tracing is enabled
+func tracingEnabled() bool {
+	tracingOnce.Do(loadTracing)
+	return tracer != nil
+}
+
+// flush and stop exporting spans
+func ShutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(ctx)
+}
+
+// rpc attributes of method
+func rpcAttributes(fullMethodName string) []attribute.KeyValue {
+	service, method := splitMethodName(fullMethodName)
+	return []attribute.KeyValue{
+		semconv.RPCSystemKey.String("grpc"),
+		semconv.RPCService(service),
+		semconv.RPCMethod(method),
+	}
+}
+
+// split /service/method
+func splitMethodName(fullMethodName string) (string, string) {
+	names := strings.SplitN(strings.TrimPrefix(fullMethodName, "/"), "/", 2)
+	if len(names) < 2 {
+		return names[0], ""
+	}
+	return names[0], names[1]
+}
+
+// extract trace context from incoming metadata and start server span
+func startServerSpan(ctx context.Context, fullMethodName string) (context.Context, trace.Span) {
+	if !tracingEnabled() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = tracingPropagator.Extract(ctx, metadataCarrier(md))
+	attrs := rpcAttributes(fullMethodName)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		attrs = append(attrs, semconv.NetSockPeerAddr(p.Addr.String()))
+	}
+	return tracer.Start(ctx, strings.TrimPrefix(fullMethodName, "/"),
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// end server span with call statistics
+func endServerSpan(span trace.Span, stats *callStats, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	endpoint, _ := stats.endpoint.Load().(string)
+	span.SetAttributes(
+		attribute.String("synapsor.proxy", stats.proxy),
+		attribute.String("synapsor.endpoint", endpoint),
+		attribute.Int64("synapsor.retries", atomic.LoadInt64(&stats.retries)),
+	)
+	endSpan(span, err)
+}
+
+// start client span of backend attempt and inject trace context into outgoing metadata
+func startClientSpan(ctx context.Context, fullMethodName string, conn *Client, attempt int) (context.Context, trace.Span) {
+	if !tracingEnabled() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	attrs := append(rpcAttributes(fullMethodName), attribute.Int("synapsor.attempt", attempt))
+	if conn != nil && conn.pool != nil {
+		attrs = append(attrs, semconv.NetPeerName(conn.pool.poolRemoteAddr))
+	}
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(fullMethodName, "/"),
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	tracingPropagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// end span with grpc status
+func endSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
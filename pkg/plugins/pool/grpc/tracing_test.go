@@ -0,0 +1,108 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// record spans in memory instead of exporting to collector
+func useTestTracer(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	tracingOnce.Do(func() {})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer = provider.Tracer("synapsor/pkg/plugins/pool/grpc")
+	tracingPropagator = propagation.TraceContext{}
+	t.Cleanup(func() {
+		tracer = nil
+		tracingPropagator = nil
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+// attribute value of span
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := useTestTracer(t)
+	addr, received := startTestBackend(t)
+	initTestProxy(t, "tracing", addr)
+	conn := startTestProxyServer(t)
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	md := metadata.Pairs("proxy", "tracing")
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(ctx, parent), metadataCarrier(md))
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("status %v", resp.Status)
+	}
+
+	waitFor(t, "server span", func() bool { return len(recorder.Ended()) == 2 })
+	var server, client sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.SpanKind() {
+		case trace.SpanKindServer:
+			server = span
+		case trace.SpanKindClient:
+			client = span
+		}
+	}
+	if server == nil || client == nil {
+		t.Fatalf("spans %v", recorder.Ended())
+	}
+	// server span continues the trace of the caller, client span is its child
+	if server.Parent().SpanID() != parent.SpanID() || server.SpanContext().TraceID() != parent.TraceID() {
+		t.Errorf("server span parent %v, want %v", server.Parent(), parent)
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() || client.SpanContext().TraceID() != parent.TraceID() {
+		t.Errorf("client span parent %v, want server span %v", client.Parent(), server.SpanContext())
+	}
+	if server.Name() != "grpc.health.v1.Health/Check" || client.Name() != server.Name() {
+		t.Errorf("span names %q %q", server.Name(), client.Name())
+	}
+	if proxy := spanAttribute(server, "synapsor.proxy").AsString(); proxy != "tracing" {
+		t.Errorf("proxy attribute %q", proxy)
+	}
+	if endpoint := spanAttribute(server, "synapsor.endpoint").AsString(); endpoint != addr {
+		t.Errorf("endpoint attribute %q, want %q", endpoint, addr)
+	}
+	if peer := spanAttribute(client, "net.peer.name").AsString(); peer != addr {
+		t.Errorf("peer attribute %q, want %q", peer, addr)
+	}
+	if code := spanAttribute(client, "rpc.grpc.status_code").AsInt64(); code != 0 {
+		t.Errorf("status code attribute %d", code)
+	}
+
+	// backend receives the context of the client span
+	backendCtx := propagation.TraceContext{}.Extract(context.Background(), metadataCarrier(received()))
+	if got := trace.SpanContextFromContext(backendCtx); got.SpanID() != client.SpanContext().SpanID() || got.TraceID() != parent.TraceID() {
+		t.Errorf("backend trace context %v, want client span %v", got, client.SpanContext())
+	}
+}
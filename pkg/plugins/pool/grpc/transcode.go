@@ -0,0 +1,661 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// transcoding content type
+const (
+	TRANSCODE_CONTENT_TYPE        = "application/json"
+	TRANSCODE_STREAM_CONTENT_TYPE = "application/x-ndjson"
+)
+
+// TranscodeRoute HTTP/JSON 到 gRPC 方法的转码路由, 来自 google.api.http 注解
+type TranscodeRoute struct {
+	ProxyName    string `json:"proxy"`
+	HTTPMethod   string `json:"http_method"`
+	Pattern      string `json:"pattern"`
+	FullMethod   string `json:"method"`
+	method       protoreflect.MethodDescriptor
+	template     *pathTemplate
+	body         string
+	responseBody string
+	types        *protoregistry.Types
+}
+
+// transcode routes of proxies
+var transcodeRoutes = make(map[string][]*TranscodeRoute)
+
+// transcode routes lock
+var transcodeLock sync.RWMutex
+
+// headers not forwarded as metadata
+var transcodeSkipHeaders = map[string]bool{
+	"connection":        true,
+	"content-length":    true,
+	"content-type":      true,
+	"host":              true,
+	"accept-encoding":   true,
+	"keep-alive":        true,
+	"proxy":             true,
+	"proxy-connection":  true,
+	"te":                true,
+	"trailer":           true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// path prefix of proxies with transcoding enabled
+var transcodeProxies = make(map[string]string)
+
+func init() {
+	onDescriptorsUpdate(updateTranscodeRoutes)
+}
+
+// init transcoding of proxy, routes are built from the descriptors registry
+func initTranscoding(proxyName string, conf map[string]interface{}) {
+	transcodeLock.Lock()
+	transcodeProxies[proxyName] = strings.TrimSuffix(configString(conf, "PREFIX", ""), "/")
+	transcodeLock.Unlock()
+	if descriptors, ok := getProxyDescriptors(proxyName); ok && !descriptors.UpdatedAt().IsZero() {
+		updateTranscodeRoutes(proxyName, descriptors)
+	}
+	watchProxyDescriptors(proxyName)
+}
+
+// rebuild transcode routes after descriptors changed
+func updateTranscodeRoutes(proxyName string, descriptors *ProxyDescriptors) {
+	transcodeLock.RLock()
+	prefix, ok := transcodeProxies[proxyName]
+	transcodeLock.RUnlock()
+	if !ok {
+		return
+	}
+	files, types := descriptors.Files()
+	setTranscodeRoutes(proxyName, buildTranscodeRoutes(proxyName, files, types, prefix))
+}
+
+// set transcode routes of proxy, sorted in match order
+func setTranscodeRoutes(proxyName string, routes []*TranscodeRoute) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].before(routes[j])
+	})
+	transcodeLock.Lock()
+	defer transcodeLock.Unlock()
+	transcodeRoutes[proxyName] = routes
+	logging.Log.Info("proxy ", proxyName, " transcoding ", strconv.Itoa(len(routes)), " routes")
+}
+
+// build transcode routes of all methods with google.api.http annotation
+func buildTranscodeRoutes(proxyName string, files *protoregistry.Files, types *protoregistry.Types, prefix string) []*TranscodeRoute {
+	var routes []*TranscodeRoute
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+				// client streaming can not be mapped to a http request
+				if method.IsStreamingClient() {
+					continue
+				}
+				opts, ok := method.Options().(*descriptorpb.MethodOptions)
+				if !ok || opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+					continue
+				}
+				rule := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+				for _, r := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+					route, err := newTranscodeRoute(proxyName, method, r, prefix)
+					if err != nil {
+						logging.ERROR.Error("transcoding method ", method.FullName(), " error: ", err)
+						continue
+					}
+					route.types = types
+					routes = append(routes, route)
+				}
+			}
+		}
+		return true
+	})
+	return routes
+}
+
+// route order of matching: route with verb first, e.g. /v1/{name}:watch before /v1/{name},
+// then more literal segments, e.g. /v1/users/me before /v1/users/{id}, then method name
+func (route *TranscodeRoute) before(other *TranscodeRoute) bool {
+	if (route.template.verb != "") != (other.template.verb != "") {
+		return route.template.verb != ""
+	}
+	if a, b := route.template.literals(), other.template.literals(); a != b {
+		return a > b
+	}
+	if route.FullMethod != other.FullMethod {
+		return route.FullMethod < other.FullMethod
+	}
+	if route.ProxyName != other.ProxyName {
+		return route.ProxyName < other.ProxyName
+	}
+	return route.Pattern < other.Pattern
+}
+
+// new transcode route of http rule
+func newTranscodeRoute(proxyName string, method protoreflect.MethodDescriptor, rule *annotations.HttpRule, prefix string) (*TranscodeRoute, error) {
+	route := &TranscodeRoute{
+		ProxyName:    proxyName,
+		FullMethod:   fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name()),
+		method:       method,
+		body:         rule.Body,
+		responseBody: rule.ResponseBody,
+	}
+	switch pattern := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		route.HTTPMethod, route.Pattern = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		route.HTTPMethod, route.Pattern = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		route.HTTPMethod, route.Pattern = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		route.HTTPMethod, route.Pattern = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		route.HTTPMethod, route.Pattern = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		route.HTTPMethod, route.Pattern = strings.ToUpper(pattern.Custom.Kind), pattern.Custom.Path
+	default:
+		return nil, fmt.Errorf("http rule pattern is empty")
+	}
+	route.Pattern = prefix + route.Pattern
+	template, err := parsePathTemplate(route.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	route.template = template
+	if route.body != "" && route.body != "*" && method.Input().Fields().ByName(protoreflect.Name(route.body)) == nil {
+		return nil, fmt.Errorf("body field %s not found", route.body)
+	}
+	if route.responseBody != "" && method.Output().Fields().ByName(protoreflect.Name(route.responseBody)) == nil {
+		return nil, fmt.Errorf("response body field %s not found", route.responseBody)
+	}
+	return route, nil
+}
+
+// get transcode routes
+func GetTranscodeRoutes() []*TranscodeRoute {
+	transcodeLock.RLock()
+	defer transcodeLock.RUnlock()
+	routes := []*TranscodeRoute{}
+	for _, proxyRoutes := range transcodeRoutes {
+		routes = append(routes, proxyRoutes...)
+	}
+	return routes
+}
+
+// match transcode route of http request, the best match of all proxies
+func matchTranscodeRoute(httpMethod, path string) (*TranscodeRoute, map[string]string) {
+	transcodeLock.RLock()
+	defer transcodeLock.RUnlock()
+	var matched *TranscodeRoute
+	var matchedVars map[string]string
+	for _, routes := range transcodeRoutes {
+		// routes of proxy are sorted, the first match is the best of proxy
+		for _, route := range routes {
+			if route.HTTPMethod != httpMethod {
+				continue
+			}
+			vars, ok := route.template.match(path)
+			if !ok {
+				continue
+			}
+			if matched == nil || route.before(matched) {
+				matched, matchedVars = route, vars
+			}
+			break
+		}
+	}
+	return matched, matchedVars
+}
+
+// transcode http request to grpc, return false if no route matched
+func TranscodeHTTP(w http.ResponseWriter, r *http.Request) bool {
+	route, vars := matchTranscodeRoute(r.Method, r.URL.Path)
+	if route == nil {
+		return false
+	}
+	route.serve(w, r, vars)
+	return true
+}
+
+// deadline of unary transcoded calls, HTTP_TIME_DURATION of the admin http server,
+// method TIMEOUT of proxy services also applies
+var TranscodeTimeout time.Duration
+
+// handler of transcoded calls, the same policies, limits, fault rules, access log, metrics and tracing as grpc calls
+var transcodeHandler = TransparentHandler(GrpcProxyTransport)
+
+// call grpc method and write json response
+func (route *TranscodeRoute) serve(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		if k = strings.ToLower(k); !transcodeSkipHeaders[k] {
+			md.Append(k, v...)
+		}
+	}
+	md.Set("proxy", route.ProxyName)
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+
+	// body is limited by max request size of the method, errors of policy are returned by the handler
+	policy, _ := matchMethodPolicy(ctx, route.FullMethod)
+	maxSize := callLimits(route.ProxyName, policy).MaxRequestSize
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize))
+	input := dynamicpb.NewMessage(route.method.Input())
+	if err := route.decodeRequest(r, vars, input); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			observeLimitExceeded(route.ProxyName, route.FullMethod, LIMIT_REQUEST_SIZE)
+			writeTranscodeErrorStatus(w, http.StatusRequestEntityTooLarge,
+				status.Errorf(codes.ResourceExhausted, "request body larger than max (%d)", maxSize))
+			return
+		}
+		writeTranscodeError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	payload, err := proto.Marshal(input)
+	if err != nil {
+		writeTranscodeError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	streaming := route.method.IsStreamingServer()
+	if !streaming && TranscodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, TranscodeTimeout)
+		defer cancel()
+	}
+
+	stream := &transcodeServerStream{
+		route:       route,
+		w:           w,
+		payload:     payload,
+		streaming:   streaming,
+		contentType: TRANSCODE_CONTENT_TYPE,
+		header:      metadata.MD{},
+	}
+	if streaming {
+		stream.contentType = TRANSCODE_STREAM_CONTENT_TYPE
+	}
+	stream.ctx = grpc.NewContextWithServerTransportStream(ctx, &transcodeTransportStream{stream})
+	stream.finish(transcodeHandler(nil, stream))
+}
+
+// transcodeServerStream 以 gRPC 服务端流的形式处理转码请求, 请求消息来自 HTTP/JSON, 响应消息编码为 JSON 写入 HTTP 响应
+type transcodeServerStream struct {
+	route       *TranscodeRoute
+	w           http.ResponseWriter
+	ctx         context.Context
+	payload     []byte
+	received    bool
+	streaming   bool
+	contentType string
+	header      metadata.MD
+	sent        int // 已写入的响应消息数
+	lock        sync.Mutex
+}
+
+// context of the call
+func (s *transcodeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// set header, written with the first response message
+func (s *transcodeServerStream) SetHeader(md metadata.MD) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sent > 0 {
+		return status.Error(codes.Internal, "transcoding header already sent")
+	}
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+// send header, written with the first response message
+func (s *transcodeServerStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+// trailers are not written to http response
+func (s *transcodeServerStream) SetTrailer(md metadata.MD) {
+}
+
+// write response message as json, one message per line for server streaming
+func (s *transcodeServerStream) SendMsg(m interface{}) error {
+	f, ok := m.(*frame)
+	if !ok {
+		return status.Errorf(codes.Internal, "transcoding unexpected message %T", m)
+	}
+	data, err := s.route.encodeResponse(f.payload)
+	if err != nil {
+		logging.ERROR.Error("transcoding encode response of ", s.route.FullMethod, " error: ", err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sent == 0 {
+		s.writeHeader()
+	}
+	s.sent++
+	s.w.Write(data)
+	if s.streaming {
+		s.w.Write([]byte("\n"))
+		if flusher, ok := s.w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+// receive the request message decoded from http request
+func (s *transcodeServerStream) RecvMsg(m interface{}) error {
+	f, ok := m.(*frame)
+	if !ok {
+		return status.Errorf(codes.Internal, "transcoding unexpected message %T", m)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.received {
+		return io.EOF
+	}
+	s.received = true
+	f.payload = s.payload
+	return nil
+}
+
+// write status of the call, error as the last line after streamed messages
+func (s *transcodeServerStream) finish(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case s.sent == 0 && err != nil:
+		writeTranscodeError(s.w, err)
+	case s.sent == 0:
+		s.writeHeader()
+	case err != nil:
+		s.w.Write(append(transcodeErrorBody("error", err), '\n'))
+	}
+}
+
+// write response header, backend header as Grpc-Metadata-*
+func (s *transcodeServerStream) writeHeader() {
+	for k, vs := range s.header {
+		if transcodeSkipHeaders[k] {
+			continue
+		}
+		for _, v := range vs {
+			s.w.Header().Add("Grpc-Metadata-"+k, v)
+		}
+	}
+	s.w.Header().Set("Content-Type", s.contentType)
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// transcodeTransportStream 转码调用的 grpc.ServerTransportStream, 提供方法名和响应 header
+type transcodeTransportStream struct {
+	stream *transcodeServerStream
+}
+
+// full method name
+func (t *transcodeTransportStream) Method() string {
+	return t.stream.route.FullMethod
+}
+
+// set header
+func (t *transcodeTransportStream) SetHeader(md metadata.MD) error {
+	return t.stream.SetHeader(md)
+}
+
+// send header
+func (t *transcodeTransportStream) SendHeader(md metadata.MD) error {
+	return t.stream.SendHeader(md)
+}
+
+// set trailer
+func (t *transcodeTransportStream) SetTrailer(md metadata.MD) error {
+	t.stream.SetTrailer(md)
+	return nil
+}
+
+// decode http body, path variables and query into request message
+func (route *TranscodeRoute) decodeRequest(r *http.Request, vars map[string]string, input *dynamicpb.Message) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	unmarshal := protojson.UnmarshalOptions{DiscardUnknown: true, Resolver: route.types}
+	if route.body != "" && len(bytes.TrimSpace(body)) > 0 {
+		if route.body == "*" {
+			if err := unmarshal.Unmarshal(body, input); err != nil {
+				return err
+			}
+		} else {
+			// body is a field of request message
+			field := input.Descriptor().Fields().ByName(protoreflect.Name(route.body))
+			wrapped := append([]byte(`{"`+field.JSONName()+`":`), body...)
+			wrapped = append(wrapped, '}')
+			fieldMessage := dynamicpb.NewMessage(route.method.Input())
+			if err := unmarshal.Unmarshal(wrapped, fieldMessage); err != nil {
+				return err
+			}
+			proto.Merge(input, fieldMessage)
+		}
+	}
+	for fieldPath, value := range vars {
+		if err := setFieldPath(input, fieldPath, []string{value}); err != nil {
+			return err
+		}
+	}
+	if route.body == "*" {
+		return nil
+	}
+	for key, values := range r.URL.Query() {
+		if _, ok := vars[key]; ok {
+			continue
+		}
+		if err := setFieldPath(input, key, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode response message as json, only the response body field if set
+func (route *TranscodeRoute) encodeResponse(payload []byte) ([]byte, error) {
+	output := dynamicpb.NewMessage(route.method.Output())
+	if err := proto.Unmarshal(payload, output); err != nil {
+		return nil, err
+	}
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true, Resolver: route.types}.Marshal(output)
+	if err != nil || route.responseBody == "" {
+		return data, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	field := output.Descriptor().Fields().ByName(protoreflect.Name(route.responseBody))
+	return fields[field.JSONName()], nil
+}
+
+// set field of message by path, e.g. book.author.name
+func setFieldPath(msg protoreflect.Message, fieldPath string, values []string) error {
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fields := msg.Descriptor().Fields()
+		field := fields.ByName(protoreflect.Name(name))
+		if field == nil {
+			field = fields.ByJSONName(name)
+		}
+		if field == nil {
+			return fmt.Errorf("field %s not found", fieldPath)
+		}
+		if i < len(names)-1 {
+			if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+				return fmt.Errorf("field %s is not a message", fieldPath)
+			}
+			msg = msg.Mutable(field).Message()
+			continue
+		}
+		if field.IsMap() {
+			return fmt.Errorf("map field %s is not supported", fieldPath)
+		}
+		if field.IsList() {
+			list := msg.Mutable(field).List()
+			for _, v := range values {
+				value, err := parseFieldValue(field, v)
+				if err != nil {
+					return err
+				}
+				list.Append(value)
+			}
+			return nil
+		}
+		value, err := parseFieldValue(field, values[len(values)-1])
+		if err != nil {
+			return err
+		}
+		msg.Set(field, value)
+	}
+	return nil
+}
+
+// parse string value of field
+func parseFieldValue(field protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if value := field.Enum().Values().ByName(protoreflect.Name(s)); value != nil {
+			return protoreflect.ValueOfEnum(value.Number()), nil
+		}
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.MessageKind:
+		// well known types, e.g. Timestamp, Duration, wrappers
+		msg := dynamicpb.NewMessage(field.Message())
+		if err := protojson.Unmarshal([]byte(s), msg); err != nil {
+			if err := protojson.Unmarshal([]byte(strconv.Quote(s)), msg); err != nil {
+				return protoreflect.Value{}, err
+			}
+		}
+		return protoreflect.ValueOfMessage(msg), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field %s", field.FullName())
+}
+
+// map grpc code to http status
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// write grpc error as http error
+func writeTranscodeError(w http.ResponseWriter, err error) {
+	writeTranscodeErrorStatus(w, HTTPStatusFromCode(status.Code(err)), err)
+}
+
+// write grpc error with http status
+func writeTranscodeErrorStatus(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", TRANSCODE_CONTENT_TYPE)
+	w.WriteHeader(code)
+	w.Write(transcodeErrorBody("", err))
+}
+
+// json body of grpc status, wrapped by key if not empty
+func transcodeErrorBody(key string, err error) []byte {
+	st := status.Convert(err)
+	body := map[string]interface{}{
+		"code":    int(st.Code()),
+		"message": st.Message(),
+	}
+	if key != "" {
+		body = map[string]interface{}{key: body}
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
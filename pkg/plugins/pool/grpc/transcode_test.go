@@ -0,0 +1,130 @@
+package grpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestTranscodeMethodPolicy(t *testing.T) {
+	services, err := parseProxyServices(map[string]interface{}{
+		"SERVICES": map[string]interface{}{
+			"UNKNOWN_METHOD": "REJECT",
+			"LIST": []interface{}{map[string]interface{}{
+				"NAME": "grpc.health.v1.Health",
+				"METHODS": []interface{}{map[string]interface{}{
+					"NAME": "Check",
+					"AUTH": map[string]interface{}{"VALUES": []interface{}{"secret"}},
+				}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProxy(t, "tc", map[string]interface{}{"services": services})
+
+	methods := healthpb.File_grpc_health_v1_health_proto.Services().ByName("Health").Methods()
+	check, err := newTranscodeRoute("tc", methods.ByName("Check"),
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/health:check"}, Body: "*"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch, err := newTranscodeRoute("tc", methods.ByName("Watch"),
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/health:watch"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(route *TranscodeRoute, auth string) int {
+		r := httptest.NewRequest(route.HTTPMethod, route.Pattern, strings.NewReader(`{"service": "hello"}`))
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		route.serve(w, r, map[string]string{})
+		return w.Code
+	}
+	// transcoded calls are authorized like grpc calls
+	if code := serve(check, ""); code != http.StatusUnauthorized {
+		t.Errorf("check without auth status %d, want %d", code, http.StatusUnauthorized)
+	}
+	// authorized, no backend endpoint
+	if code := serve(check, "secret"); code != http.StatusServiceUnavailable {
+		t.Errorf("check status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := serve(watch, "secret"); code != http.StatusNotImplemented {
+		t.Errorf("unknown method status %d, want %d", code, http.StatusNotImplemented)
+	}
+}
+
+func TestMatchTranscodeRouteOrder(t *testing.T) {
+	methods := healthpb.File_grpc_health_v1_health_proto.Services().ByName("Health").Methods()
+	newRoute := func(proxyName, method, pattern string) *TranscodeRoute {
+		route, err := newTranscodeRoute(proxyName, methods.ByName(protoreflect.Name(method)),
+			&annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: pattern}}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return route
+	}
+	setTranscodeRoutes("order-a", []*TranscodeRoute{
+		newRoute("order-a", "Watch", "/v1/{service}"),
+		newRoute("order-a", "Check", "/v1/{service}:check"),
+		newRoute("order-a", "Check", "/v1/health"),
+	})
+	setTranscodeRoutes("order-b", []*TranscodeRoute{
+		newRoute("order-b", "Watch", "/v1/health/{service}"),
+		newRoute("order-b", "Check", "/v1/{service}/status"),
+	})
+	t.Cleanup(func() {
+		transcodeLock.Lock()
+		defer transcodeLock.Unlock()
+		delete(transcodeRoutes, "order-a")
+		delete(transcodeRoutes, "order-b")
+	})
+
+	cases := []struct {
+		path, proxy, pattern string
+	}{
+		// verb first
+		{"/v1/hello:check", "order-a", "/v1/{service}:check"},
+		// more literal segments before variables, of any proxy
+		{"/v1/health", "order-a", "/v1/health"},
+		{"/v1/health/hello", "order-b", "/v1/health/{service}"},
+		{"/v1/hello", "order-a", "/v1/{service}"},
+		// the same literal segments, by method name
+		{"/v1/health/status", "order-b", "/v1/{service}/status"},
+	}
+	for _, c := range cases {
+		// the same route every time regardless of map order
+		for i := 0; i < 10; i++ {
+			route, _ := matchTranscodeRoute(http.MethodGet, c.path)
+			if route == nil || route.ProxyName != c.proxy || route.Pattern != c.pattern {
+				t.Fatalf("path %s matched %+v, want %s %s", c.path, route, c.proxy, c.pattern)
+			}
+		}
+	}
+}
+
+func TestTranscodeMaxRequestSize(t *testing.T) {
+	testProxy(t, "tclimit", map[string]interface{}{"limits": &MessageLimits{MaxRequestSize: 16}})
+
+	check := healthpb.File_grpc_health_v1_health_proto.Services().ByName("Health").Methods().ByName("Check")
+	route, err := newTranscodeRoute("tclimit", check,
+		&annotations.HttpRule{Pattern: &annotations.HttpRule_Post{Post: "/v1/health:check"}, Body: "*"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(route.HTTPMethod, route.Pattern, strings.NewReader(`{"service": "`+strings.Repeat("x", 64)+`"}`))
+	w := httptest.NewRecorder()
+	route.serve(w, r, map[string]string{})
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":8`) {
+		t.Errorf("status %d body %s, want %d RESOURCE_EXHAUSTED", w.Code, w.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,528 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"sync"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// xds metadata filter of endpoint labels, same as envoy subset load balancer
+const XDS_LB_METADATA = "envoy.lb"
+
+// xds client max reconnect delay
+var XdsMaxBackoff = 30 * time.Second
+
+// XdsClient xDS 客户端, 通过 ADS 从控制面获取 listener、route、cluster、endpoint,
+// cluster 转换为 proxy, endpoint 转换为 proxy 的连接池, route 用于选择 proxy
+type XdsClient struct {
+	serverAddr    string
+	node          *corev3.Node
+	proxyTemplate string
+	drainTimeout  time.Duration
+	stream        discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesClient
+	lock          sync.Mutex
+	versions      map[string]string   // type url -> accepted version
+	nonces        map[string]string   // type url -> last nonce
+	names         map[string][]string // type url -> subscribed resource names
+	listeners     map[string]*listenerv3.Listener
+	routeConfigs  map[string]*routev3.RouteConfiguration
+	clusters      map[string]*clusterv3.Cluster
+	edsClusters   map[string][]string // eds service name -> cluster names
+}
+
+// new xds client
+func NewXdsClient(conf map[string]interface{}) (*XdsClient, error) {
+	serverAddr := configString(conf, "server_addr", "")
+	if serverAddr == "" {
+		return nil, errors.New("xds server_addr is empty")
+	}
+	return &XdsClient{
+		serverAddr: serverAddr,
+		node: &corev3.Node{
+			Id:            configString(conf, "node_id", "synapsor"),
+			Cluster:       configString(conf, "cluster", "synapsor"),
+			UserAgentName: "synapsor",
+		},
+		proxyTemplate: configString(conf, "proxy_template", ""),
+		drainTimeout:  time.Duration(configInt(conf, "drain_timeout", int(DrainTimeout/time.Second))) * time.Second,
+		versions:      make(map[string]string),
+		nonces:        make(map[string]string),
+		names:         make(map[string][]string),
+		listeners:     make(map[string]*listenerv3.Listener),
+		routeConfigs:  make(map[string]*routev3.RouteConfiguration),
+		clusters:      make(map[string]*clusterv3.Cluster),
+		edsClusters:   make(map[string][]string),
+	}, nil
+}
+
+// start xds client, conf is the xds setting of ProxyConfig
+func startXdsClient(conf map[string]interface{}) {
+	client, err := NewXdsClient(conf)
+	if err != nil {
+		logging.ERROR.Error("init xds client error: ", err)
+		return
+	}
+	go func() {
+		if err := client.Run(discoveryCtx); err != nil {
+			logging.ERROR.Error("xds client stopped: ", err)
+		}
+	}()
+}
+
+// run ads stream until ctx done, reconnect with backoff
+func (c *XdsClient) Run(ctx context.Context) error {
+	conn, err := grpc.DialContext(ctx, c.serverAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := c.runStream(ctx, discoveryv3.NewAggregatedDiscoveryServiceClient(conn))
+		if ctx.Err() != nil {
+			return nil
+		}
+		logging.ERROR.Error("xds stream of ", c.serverAddr, " broken: ", err)
+		// reset backoff when the stream has worked for a while
+		if time.Since(start) > XdsMaxBackoff {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > XdsMaxBackoff {
+			backoff = XdsMaxBackoff
+		}
+	}
+}
+
+// open ads stream, subscribe resources and handle responses
+func (c *XdsClient) runStream(ctx context.Context, ads discoveryv3.AggregatedDiscoveryServiceClient) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := ads.StreamAggregatedResources(streamCtx)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.stream = stream
+	c.nonces = make(map[string]string)
+	c.lock.Unlock()
+
+	// listener and cluster are wildcard, route and endpoint names come from them
+	for _, typeURL := range []string{resourcev3.ListenerType, resourcev3.ClusterType, resourcev3.RouteType, resourcev3.EndpointType} {
+		if typeURL != resourcev3.ListenerType && typeURL != resourcev3.ClusterType && len(c.names[typeURL]) == 0 {
+			continue
+		}
+		if err := c.send(typeURL, nil); err != nil {
+			return err
+		}
+	}
+	logging.Log.Info("xds stream of ", c.serverAddr, " connected")
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := c.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// send discovery request, ack when errDetail is nil
+func (c *XdsClient) send(typeURL string, errDetail error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	req := &discoveryv3.DiscoveryRequest{
+		Node:          c.node,
+		TypeUrl:       typeURL,
+		VersionInfo:   c.versions[typeURL],
+		ResourceNames: c.names[typeURL],
+		ResponseNonce: c.nonces[typeURL],
+	}
+	if errDetail != nil {
+		req.ErrorDetail = &rpcstatus.Status{Code: int32(codes.InvalidArgument), Message: errDetail.Error()}
+	}
+	return c.stream.Send(req)
+}
+
+// handle discovery response, apply resources and ack or nack
+func (c *XdsClient) handleResponse(resp *discoveryv3.DiscoveryResponse) error {
+	var err error
+	var subscribe string
+	switch resp.TypeUrl {
+	case resourcev3.ListenerType:
+		err = c.applyListeners(resp.Resources)
+		subscribe = resourcev3.RouteType
+	case resourcev3.RouteType:
+		err = c.applyRouteConfigs(resp.Resources)
+	case resourcev3.ClusterType:
+		err = c.applyClusters(resp.Resources)
+		subscribe = resourcev3.EndpointType
+	case resourcev3.EndpointType:
+		err = c.applyEndpoints(resp.Resources)
+	default:
+		err = fmt.Errorf("unsupported type %s", resp.TypeUrl)
+	}
+
+	c.lock.Lock()
+	c.nonces[resp.TypeUrl] = resp.Nonce
+	if err == nil {
+		c.versions[resp.TypeUrl] = resp.VersionInfo
+	}
+	c.lock.Unlock()
+	if err != nil {
+		logging.ERROR.Error("xds reject ", resp.TypeUrl, " version ", resp.VersionInfo, ": ", err)
+	}
+	if sendErr := c.send(resp.TypeUrl, err); sendErr != nil {
+		return sendErr
+	}
+	// subscribe route and endpoint names found in listeners and clusters
+	if err == nil && subscribe != "" && c.updateNames(subscribe) {
+		return c.send(subscribe, nil)
+	}
+	return nil
+}
+
+// update subscribed names of route or endpoint, return whether changed
+func (c *XdsClient) updateNames(typeURL string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	nameSet := make(map[string]bool)
+	switch typeURL {
+	case resourcev3.RouteType:
+		for _, listener := range c.listeners {
+			rdsName, _ := listenerRoute(listener)
+			if rdsName != "" {
+				nameSet[rdsName] = true
+			}
+		}
+	case resourcev3.EndpointType:
+		for name := range c.edsClusters {
+			nameSet[name] = true
+		}
+	}
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") == strings.Join(c.names[typeURL], ",") {
+		return false
+	}
+	c.names[typeURL] = names
+	return true
+}
+
+// apply listeners, all listeners are sent in every response
+func (c *XdsClient) applyListeners(resources []*anypb.Any) error {
+	listeners := make(map[string]*listenerv3.Listener)
+	for _, res := range resources {
+		listener := &listenerv3.Listener{}
+		if err := res.UnmarshalTo(listener); err != nil {
+			return err
+		}
+		if _, err := listenerManager(listener); err != nil {
+			return fmt.Errorf("listener %s: %v", listener.Name, err)
+		}
+		listeners[listener.Name] = listener
+	}
+	c.lock.Lock()
+	c.listeners = listeners
+	c.lock.Unlock()
+	c.rebuildRoutes()
+	return nil
+}
+
+// apply route configurations
+func (c *XdsClient) applyRouteConfigs(resources []*anypb.Any) error {
+	routeConfigs := make(map[string]*routev3.RouteConfiguration)
+	for _, res := range resources {
+		routeConfig := &routev3.RouteConfiguration{}
+		if err := res.UnmarshalTo(routeConfig); err != nil {
+			return err
+		}
+		if _, err := newXdsVirtualHosts(routeConfig); err != nil {
+			return fmt.Errorf("route %s: %v", routeConfig.Name, err)
+		}
+		routeConfigs[routeConfig.Name] = routeConfig
+	}
+	c.lock.Lock()
+	c.routeConfigs = routeConfigs
+	c.lock.Unlock()
+	c.rebuildRoutes()
+	return nil
+}
+
+// rebuild proxy routes of listeners, listeners are matched by name order
+func (c *XdsClient) rebuildRoutes() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	names := make([]string, 0, len(c.listeners))
+	for name := range c.listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var virtualHosts []*xdsVirtualHost
+	for _, name := range names {
+		rdsName, routeConfig := listenerRoute(c.listeners[name])
+		if rdsName != "" {
+			routeConfig = c.routeConfigs[rdsName]
+		}
+		if routeConfig == nil {
+			continue
+		}
+		hosts, _ := newXdsVirtualHosts(routeConfig)
+		virtualHosts = append(virtualHosts, hosts...)
+	}
+	setXdsRoutes(virtualHosts)
+}
+
+// apply clusters, clusters are converted to proxies
+func (c *XdsClient) applyClusters(resources []*anypb.Any) error {
+	clusters := make(map[string]*clusterv3.Cluster)
+	for _, res := range resources {
+		cluster := &clusterv3.Cluster{}
+		if err := res.UnmarshalTo(cluster); err != nil {
+			return err
+		}
+		clusters[cluster.Name] = cluster
+	}
+
+	edsClusters := make(map[string][]string)
+	for name, cluster := range clusters {
+		if !c.initXdsProxy(name, cluster) {
+			// clusters of static proxies are not tracked, never removed by xds
+			delete(clusters, name)
+			continue
+		}
+		switch {
+		case cluster.GetType() == clusterv3.Cluster_EDS:
+			serviceName := cluster.GetEdsClusterConfig().GetServiceName()
+			if serviceName == "" {
+				serviceName = name
+			}
+			edsClusters[serviceName] = append(edsClusters[serviceName], name)
+		case cluster.LoadAssignment != nil:
+			// static cluster with inline endpoints
+			syncProxyEndpoints(name, xdsEndpoints(cluster.LoadAssignment), c.drainTimeout)
+		}
+	}
+
+	c.lock.Lock()
+	removed := make([]string, 0)
+	connLock.RLock()
+	for name := range c.clusters {
+		if _, ok := clusters[name]; !ok && connProxy[name]["xds"] == true {
+			removed = append(removed, name)
+		}
+	}
+	connLock.RUnlock()
+	c.clusters = clusters
+	c.edsClusters = edsClusters
+	c.lock.Unlock()
+	// remove proxies of deleted clusters
+	for _, name := range removed {
+		removeXdsProxy(name, c.drainTimeout)
+	}
+	return nil
+}
+
+// apply cluster load assignments
+func (c *XdsClient) applyEndpoints(resources []*anypb.Any) error {
+	assignments := make([]*endpointv3.ClusterLoadAssignment, 0, len(resources))
+	for _, res := range resources {
+		cla := &endpointv3.ClusterLoadAssignment{}
+		if err := res.UnmarshalTo(cla); err != nil {
+			return err
+		}
+		assignments = append(assignments, cla)
+	}
+	for _, cla := range assignments {
+		c.lock.Lock()
+		clusterNames := c.edsClusters[cla.ClusterName]
+		c.lock.Unlock()
+		for _, name := range clusterNames {
+			syncProxyEndpoints(name, xdsEndpoints(cla), c.drainTimeout)
+		}
+	}
+	return nil
+}
+
+// init proxy of cluster, proxies of static config are not changed by xds
+func (c *XdsClient) initXdsProxy(name string, cluster *clusterv3.Cluster) bool {
+	proxyModel := "randomWeight"
+	if cluster.GetLbPolicy() == clusterv3.Cluster_LEAST_REQUEST {
+		proxyModel = "minConn"
+	}
+
+	connLock.Lock()
+	proxy, exists := connProxy[name]
+	if exists && proxy["xds"] != true {
+		connLock.Unlock()
+		logging.ERROR.Error("xds cluster ", name, " ignored, proxy of static config exists")
+		return false
+	}
+	if exists {
+		proxy["proxyModel"] = proxyModel
+		connLock.Unlock()
+		return true
+	}
+	template, _ := connProxy[c.proxyTemplate]["proxyInitMap"].(map[string]interface{})
+	connLock.Unlock()
+
+	proxyInitMap := map[string]interface{}{
+		"grpcRequestReusable": true,
+		"requestIdleTime":     10,
+		"requestMaxLife":      60,
+		"requestTimeout":      3,
+		"poolEnabled":         true,
+		"connNum":             4,
+		"poolModel":           1,
+	}
+	for k, v := range template {
+		proxyInitMap[k] = v
+	}
+	proxyInitMap["proxyName"] = name
+	proxyInitMap["proxyModel"] = proxyModel
+	initGrpcProxy(name, proxyInitMap)
+
+	connLock.Lock()
+	connProxy[name]["xds"] = true
+	connLock.Unlock()
+	initProxyDescriptors(name, nil)
+	logging.Log.Info("xds add proxy ", name)
+	return true
+}
+
+// remove proxy of deleted cluster, pools are closed after drain timeout
+func removeXdsProxy(name string, drainTimeout time.Duration) {
+	connLock.RLock()
+	if connProxy[name]["xds"] != true {
+		connLock.RUnlock()
+		return
+	}
+	pools := make([]*Pool, 0, len(connPools[name]))
+	for _, pool := range connPools[name] {
+		pools = append(pools, pool)
+	}
+	connLock.RUnlock()
+	for _, pool := range pools {
+		retireGrpcPool(name, pool, drainTimeout)
+	}
+
+	connLock.Lock()
+	delete(connPools, name)
+	delete(connProxy, name)
+	connLock.Unlock()
+	removeProxyDescriptors(name)
+	logging.Log.Info("xds remove proxy ", name)
+}
+
+// convert cluster load assignment to endpoints, unhealthy endpoints are skipped
+func xdsEndpoints(cla *endpointv3.ClusterLoadAssignment) []Endpoint {
+	var endpoints []Endpoint
+	for _, locality := range cla.Endpoints {
+		for _, lbEndpoint := range locality.LbEndpoints {
+			switch lbEndpoint.HealthStatus {
+			case corev3.HealthStatus_UNKNOWN, corev3.HealthStatus_HEALTHY:
+			default:
+				continue
+			}
+			address := lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress()
+			if address == nil {
+				continue
+			}
+			weight := int32(lbEndpoint.GetLoadBalancingWeight().GetValue())
+			if weight <= 0 {
+				weight = 1
+			}
+			labels := make(map[string]string)
+			if l := locality.Locality; l != nil {
+				for k, v := range map[string]string{"region": l.Region, "zone": l.Zone, "sub_zone": l.SubZone} {
+					if v != "" {
+						labels[k] = v
+					}
+				}
+			}
+			if lbMetadata, ok := lbEndpoint.GetMetadata().GetFilterMetadata()[XDS_LB_METADATA]; ok {
+				for k, v := range lbMetadata.Fields {
+					labels[k] = fmt.Sprintf("%v", v.AsInterface())
+				}
+			}
+			endpoints = append(endpoints, Endpoint{
+				Addr:   net.JoinHostPort(address.Address, strconv.Itoa(int(address.GetPortValue()))),
+				Weight: weight,
+				Labels: labels,
+			})
+		}
+	}
+	return endpoints
+}
+
+// get http connection manager of listener, api listener first
+func listenerManager(listener *listenerv3.Listener) (*hcmv3.HttpConnectionManager, error) {
+	if apiListener := listener.GetApiListener().GetApiListener(); apiListener != nil {
+		manager := &hcmv3.HttpConnectionManager{}
+		if err := apiListener.UnmarshalTo(manager); err != nil {
+			return nil, err
+		}
+		return manager, nil
+	}
+	for _, chain := range listener.FilterChains {
+		for _, filter := range chain.Filters {
+			typedConfig := filter.GetTypedConfig()
+			if typedConfig == nil || !typedConfig.MessageIs(&hcmv3.HttpConnectionManager{}) {
+				continue
+			}
+			manager := &hcmv3.HttpConnectionManager{}
+			if err := typedConfig.UnmarshalTo(manager); err != nil {
+				return nil, err
+			}
+			return manager, nil
+		}
+	}
+	return nil, nil
+}
+
+// get rds name or inline route config of listener
+func listenerRoute(listener *listenerv3.Listener) (string, *routev3.RouteConfiguration) {
+	manager, _ := listenerManager(listener)
+	if manager == nil {
+		return "", nil
+	}
+	if rds := manager.GetRds(); rds != nil {
+		return rds.RouteConfigName, nil
+	}
+	return "", manager.GetRouteConfig()
+}
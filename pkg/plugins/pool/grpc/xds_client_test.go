@@ -0,0 +1,192 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// start local ads server of go-control-plane
+func startXdsControlPlane(t *testing.T) (cachev3.SnapshotCache, string) {
+	t.Helper()
+	snapshotCache := cachev3.NewSnapshotCache(true, xdsNodeHash{}, nil)
+	srv := grpc.NewServer()
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(srv, serverv3.NewServer(context.Background(), snapshotCache, nil))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return snapshotCache, lis.Addr().String()
+}
+
+func setXdsTestSnapshot(t *testing.T, snapshotCache cachev3.SnapshotCache, version int, resources map[resourcev3.Type][]types.Resource) {
+	t.Helper()
+	snapshot, err := cachev3.NewSnapshot(strconv.Itoa(version), resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshotCache.SetSnapshot(context.Background(), XDS_SNAPSHOT_NODE, snapshot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func xdsTestEdsCluster(name string) *clusterv3.Cluster {
+	return &clusterv3.Cluster{
+		Name:                 name,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+			EdsConfig: &corev3.ConfigSource{
+				ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+			},
+		},
+		LbPolicy: clusterv3.Cluster_LEAST_REQUEST,
+	}
+}
+
+// load assignment of host:port addresses in zone a
+func xdsTestLoadAssignment(t *testing.T, name string, addrs ...string) *endpointv3.ClusterLoadAssignment {
+	t.Helper()
+	locality := &endpointv3.LocalityLbEndpoints{Locality: &corev3.Locality{Zone: "a"}}
+	for _, addr := range addrs {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port, _ := strconv.Atoi(portStr)
+		locality.LbEndpoints = append(locality.LbEndpoints, &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+					Address:       host,
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(port)},
+				}}},
+			}},
+			LoadBalancingWeight: wrapperspb.UInt32(3),
+		})
+	}
+	return &endpointv3.ClusterLoadAssignment{ClusterName: name, Endpoints: []*endpointv3.LocalityLbEndpoints{locality}}
+}
+
+// endpoint addresses of proxy pools
+func xdsTestAddrs(proxyName string) []string {
+	connLock.RLock()
+	defer connLock.RUnlock()
+	addrs := []string{}
+	for _, pool := range connPools[proxyName] {
+		addrs = append(addrs, pool.poolRemoteAddr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestXdsClient(t *testing.T) {
+	snapshotCache, addr := startXdsControlPlane(t)
+
+	// proxy of static config with the same name as a cluster
+	initGrpcProxy("xds-static", map[string]interface{}{"proxyModel": "randomWeight"})
+	staticPool := &Pool{name: "xds-static-pool", poolRemoteAddr: "127.0.0.1:1", status: true}
+	connLock.Lock()
+	connPools["xds-static"][staticPool.name] = staticPool
+	connLock.Unlock()
+	t.Cleanup(func() {
+		for _, name := range []string{"xds-static", "xds-hello", "xds-inline"} {
+			removeXdsProxy(name, 0)
+			connLock.Lock()
+			delete(connPools, name)
+			delete(connProxy, name)
+			connLock.Unlock()
+		}
+		setXdsRoutes(nil)
+	})
+
+	listener, err := xdsListener("xds-hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline := &clusterv3.Cluster{
+		Name:                 "xds-inline",
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC},
+		LoadAssignment:       xdsTestLoadAssignment(t, "xds-inline", "[fd00::1]:9000"),
+	}
+	setXdsTestSnapshot(t, snapshotCache, 1, map[resourcev3.Type][]types.Resource{
+		resourcev3.ClusterType: {xdsTestEdsCluster("xds-hello"), xdsTestEdsCluster("xds-static"), inline},
+		// ads cache only responds when all endpoint resources are requested, xds-static is not subscribed
+		resourcev3.EndpointType: {xdsTestLoadAssignment(t, "xds-hello", "127.0.0.1:50051", "[fd00::2]:50052")},
+		resourcev3.ListenerType: {listener},
+		resourcev3.RouteType:    {xdsRouteConfig("xds-hello")},
+	})
+
+	client, err := NewXdsClient(map[string]interface{}{"server_addr": addr, "drain_timeout": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go client.Run(ctx)
+
+	waitFor(t, "eds endpoints", func() bool {
+		return reflect.DeepEqual(xdsTestAddrs("xds-hello"), []string{"127.0.0.1:50051", "[fd00::2]:50052"})
+	})
+	waitFor(t, "inline endpoints", func() bool {
+		return reflect.DeepEqual(xdsTestAddrs("xds-inline"), []string{"[fd00::1]:9000"})
+	})
+	waitFor(t, "routes", func() bool {
+		proxyName, ok := resolveXdsProxy("/hello.Greeter/SayHello", metadata.MD{})
+		return ok && proxyName == "xds-hello"
+	})
+
+	connLock.RLock()
+	proxyModel := connProxy["xds-hello"]["proxyModel"]
+	var labels map[string]string
+	var weight int32
+	for _, pool := range connPools["xds-hello"] {
+		labels, weight = pool.labels, pool.weight
+	}
+	connLock.RUnlock()
+	if proxyModel != "minConn" {
+		t.Errorf("proxy model %v, want minConn", proxyModel)
+	}
+	if weight != 3 || !reflect.DeepEqual(labels, map[string]string{"zone": "a"}) {
+		t.Errorf("weight %d labels %v", weight, labels)
+	}
+	if addrs := xdsTestAddrs("xds-static"); !reflect.DeepEqual(addrs, []string{"127.0.0.1:1"}) {
+		t.Errorf("static proxy endpoints %v changed by xds", addrs)
+	}
+
+	// clusters removed from CDS
+	setXdsTestSnapshot(t, snapshotCache, 2, map[resourcev3.Type][]types.Resource{
+		resourcev3.ClusterType: {inline},
+	})
+	waitFor(t, "removed proxy", func() bool {
+		connLock.RLock()
+		defer connLock.RUnlock()
+		_, ok := connProxy["xds-hello"]
+		return !ok
+	})
+	connLock.RLock()
+	_, staticExists := connProxy["xds-static"]
+	staticPools := len(connPools["xds-static"])
+	connLock.RUnlock()
+	if !staticExists || staticPools != 1 || !staticPool.status {
+		t.Errorf("static proxy removed by xds, exists %v pools %d", staticExists, staticPools)
+	}
+	if addrs := xdsTestAddrs("xds-inline"); !reflect.DeepEqual(addrs, []string{"[fd00::1]:9000"}) {
+		t.Errorf("inline endpoints %v", addrs)
+	}
+}
@@ -0,0 +1,249 @@
+package grpc
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/grpc/metadata"
+)
+
+// xdsVirtualHost xDS 虚拟主机, 按 :authority 匹配 domains
+type xdsVirtualHost struct {
+	domains []string
+	routes  []*xdsRoute
+}
+
+// xdsRoute xDS 路由, 按 gRPC 方法和 metadata 选择 proxy (cluster)
+type xdsRoute struct {
+	prefix        string
+	path          string
+	regex         *regexp.Regexp
+	headers       []*xdsHeaderMatch
+	clusterHeader string
+	clusters      []string
+	weights       []float32
+}
+
+// xdsHeaderMatch xDS header 匹配条件
+type xdsHeaderMatch struct {
+	match  *MetadataMatch
+	invert bool
+}
+
+// xds routes of listeners
+var xdsRoutes []*xdsVirtualHost
+
+// xds routes lock
+var xdsRoutesLock sync.RWMutex
+
+// set xds routes
+func setXdsRoutes(virtualHosts []*xdsVirtualHost) {
+	xdsRoutesLock.Lock()
+	defer xdsRoutesLock.Unlock()
+	xdsRoutes = virtualHosts
+}
+
+// resolve proxy name by xds routes
+func resolveXdsProxy(fullMethodName string, md metadata.MD) (string, bool) {
+	xdsRoutesLock.RLock()
+	defer xdsRoutesLock.RUnlock()
+
+	authority := ""
+	if authorities := md.Get(":authority"); len(authorities) > 0 {
+		authority = authorities[0]
+	}
+	for _, virtualHost := range xdsRoutes {
+		if !virtualHost.matchDomain(authority) {
+			continue
+		}
+		for _, route := range virtualHost.routes {
+			if route.matches(fullMethodName, md) {
+				return route.cluster(md)
+			}
+		}
+	}
+	return "", false
+}
+
+// convert route configuration to virtual hosts
+func newXdsVirtualHosts(routeConfig *routev3.RouteConfiguration) ([]*xdsVirtualHost, error) {
+	var virtualHosts []*xdsVirtualHost
+	for _, vh := range routeConfig.VirtualHosts {
+		virtualHost := &xdsVirtualHost{domains: vh.Domains}
+		for _, r := range vh.Routes {
+			route, err := newXdsRoute(r)
+			if err != nil {
+				return nil, err
+			}
+			// only routes to clusters are supported
+			if route != nil {
+				virtualHost.routes = append(virtualHost.routes, route)
+			}
+		}
+		virtualHosts = append(virtualHosts, virtualHost)
+	}
+	return virtualHosts, nil
+}
+
+// convert route
+func newXdsRoute(r *routev3.Route) (*xdsRoute, error) {
+	action := r.GetRoute()
+	if action == nil {
+		return nil, nil
+	}
+	route := &xdsRoute{}
+	match := r.GetMatch()
+	switch {
+	case match.GetSafeRegex() != nil:
+		regex, err := regexp.Compile(match.GetSafeRegex().Regex)
+		if err != nil {
+			return nil, err
+		}
+		route.regex = regex
+	case match.GetPath() != "":
+		route.path = match.GetPath()
+	default:
+		route.prefix = match.GetPrefix()
+	}
+	for _, header := range match.GetHeaders() {
+		headerMatch, err := newXdsHeaderMatch(header)
+		if err != nil {
+			return nil, err
+		}
+		route.headers = append(route.headers, headerMatch)
+	}
+
+	switch {
+	case action.GetCluster() != "":
+		route.clusters = []string{action.GetCluster()}
+		route.weights = []float32{1}
+	case action.GetWeightedClusters() != nil:
+		for _, cluster := range action.GetWeightedClusters().Clusters {
+			route.clusters = append(route.clusters, cluster.Name)
+			route.weights = append(route.weights, float32(cluster.GetWeight().GetValue()))
+		}
+	case action.GetClusterHeader() != "":
+		route.clusterHeader = strings.ToLower(action.GetClusterHeader())
+	default:
+		return nil, nil
+	}
+	return route, nil
+}
+
+// convert header matcher to metadata match
+func newXdsHeaderMatch(header *routev3.HeaderMatcher) (*xdsHeaderMatch, error) {
+	var match *MetadataMatch
+	var err error
+	stringMatch := header.GetStringMatch()
+	switch {
+	case header.GetPresentMatch():
+		match, err = NewMetadataMatch(header.Name, MATCH_PRESENT, "")
+	case header.GetExactMatch() != "":
+		match, err = NewMetadataMatch(header.Name, MATCH_EXACT, header.GetExactMatch())
+	case header.GetPrefixMatch() != "":
+		match, err = NewMetadataMatch(header.Name, MATCH_PREFIX, header.GetPrefixMatch())
+	case header.GetSuffixMatch() != "":
+		match, err = NewMetadataMatch(header.Name, MATCH_REGEX, regexp.QuoteMeta(header.GetSuffixMatch())+"$")
+	case header.GetSafeRegexMatch() != nil:
+		match, err = NewMetadataMatch(header.Name, MATCH_REGEX, "^(?:"+header.GetSafeRegexMatch().Regex+")$")
+	case stringMatch != nil:
+		match, err = newStringMetadataMatch(header.Name, stringMatch)
+	default:
+		return nil, fmt.Errorf("unsupported header matcher of %s", header.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &xdsHeaderMatch{match: match, invert: header.InvertMatch}, nil
+}
+
+// convert string matcher to metadata match
+func newStringMetadataMatch(key string, m *matcherv3.StringMatcher) (*MetadataMatch, error) {
+	switch {
+	case m.GetExact() != "":
+		return NewMetadataMatch(key, MATCH_EXACT, m.GetExact())
+	case m.GetPrefix() != "":
+		return NewMetadataMatch(key, MATCH_PREFIX, m.GetPrefix())
+	case m.GetSuffix() != "":
+		return NewMetadataMatch(key, MATCH_REGEX, regexp.QuoteMeta(m.GetSuffix())+"$")
+	case m.GetContains() != "":
+		return NewMetadataMatch(key, MATCH_REGEX, regexp.QuoteMeta(m.GetContains()))
+	case m.GetSafeRegex() != nil:
+		return NewMetadataMatch(key, MATCH_REGEX, "^(?:"+m.GetSafeRegex().Regex+")$")
+	}
+	return nil, fmt.Errorf("unsupported string matcher of %s", key)
+}
+
+// match domain of virtual host, support *, *.suffix and prefix.*
+func (vh *xdsVirtualHost) matchDomain(authority string) bool {
+	// port of authority is ignored
+	if i := strings.LastIndex(authority, ":"); i > 0 && !strings.HasSuffix(authority, "]") {
+		authority = authority[:i]
+	}
+	for _, domain := range vh.domains {
+		switch {
+		case domain == "*" || domain == authority:
+			return true
+		case strings.HasPrefix(domain, "*") && strings.HasSuffix(authority, domain[1:]):
+			return true
+		case strings.HasSuffix(domain, "*") && strings.HasPrefix(authority, domain[:len(domain)-1]):
+			return true
+		}
+	}
+	return false
+}
+
+// match grpc method and metadata
+func (r *xdsRoute) matches(fullMethodName string, md metadata.MD) bool {
+	switch {
+	case r.regex != nil:
+		if !r.regex.MatchString(fullMethodName) {
+			return false
+		}
+	case r.path != "":
+		if r.path != fullMethodName {
+			return false
+		}
+	default:
+		if !strings.HasPrefix(fullMethodName, r.prefix) {
+			return false
+		}
+	}
+	for _, header := range r.headers {
+		if header.match.Matches(md) == header.invert {
+			return false
+		}
+	}
+	return true
+}
+
+// select cluster of route
+func (r *xdsRoute) cluster(md metadata.MD) (string, bool) {
+	if r.clusterHeader != "" {
+		values := md.Get(r.clusterHeader)
+		if len(values) < 1 {
+			return "", false
+		}
+		return values[0], true
+	}
+	if len(r.clusters) < 1 {
+		return "", false
+	}
+	if len(r.clusters) == 1 {
+		return r.clusters[0], true
+	}
+	// weighted clusters
+	var sum float32
+	for _, w := range r.weights {
+		sum += w
+	}
+	if sum <= 0 {
+		return r.clusters[rand.Intn(len(r.clusters))], true
+	}
+	return r.clusters[weightedRandomIndex(r.weights)], true
+}
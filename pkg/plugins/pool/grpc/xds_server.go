@@ -0,0 +1,302 @@
+package grpc
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// snapshot node id, all xds clients share the same snapshot
+const XDS_SNAPSHOT_NODE = "synapsor"
+
+// XdsServer xDS 管理服务, 将 connPools 中的 endpoint、权重和健康状态发布为 CDS/EDS,
+// 同时为每个 proxy 发布 LDS/RDS, proxyless gRPC 客户端可以使用 xds:///<proxy> 访问
+type XdsServer struct {
+	cache    cachev3.SnapshotCache
+	server   serverv3.Server
+	interval time.Duration
+	version  int64
+	hash     uint64
+}
+
+// all nodes use the same snapshot
+type xdsNodeHash struct{}
+
+// node id of snapshot
+func (xdsNodeHash) ID(node *corev3.Node) string {
+	return XDS_SNAPSHOT_NODE
+}
+
+// new xds server, conf is the xds_server setting of ProxyConfig
+func NewXdsServer(conf map[string]interface{}) *XdsServer {
+	// not ads mode: ads mode only responds when a request names every resource of the snapshot,
+	// proxyless clients subscribe only the clusters and routes they use
+	snapshotCache := cachev3.NewSnapshotCache(false, xdsNodeHash{}, nil)
+	return &XdsServer{
+		cache:    snapshotCache,
+		server:   serverv3.NewServer(context.Background(), snapshotCache, nil),
+		interval: time.Duration(configInt(conf, "refresh_interval", 5)) * time.Second,
+	}
+}
+
+// register xds services to grpc server
+func (s *XdsServer) Register(srv *grpc.Server) {
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(srv, s.server)
+	clusterservice.RegisterClusterDiscoveryServiceServer(srv, s.server)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(srv, s.server)
+	listenerservice.RegisterListenerDiscoveryServiceServer(srv, s.server)
+	routeservice.RegisterRouteDiscoveryServiceServer(srv, s.server)
+}
+
+// refresh snapshot on interval until synapsor stops
+func (s *XdsServer) Start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			if err := s.refresh(); err != nil {
+				logging.ERROR.Error("xds server refresh snapshot error: ", err)
+			}
+			select {
+			case <-discoveryCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refresh snapshot when the endpoint view changed
+func (s *XdsServer) refresh() error {
+	resources := xdsResources()
+	hash, err := xdsResourcesHash(resources)
+	if err != nil {
+		return err
+	}
+	if s.version > 0 && hash == s.hash {
+		return nil
+	}
+	snapshot, err := cachev3.NewSnapshot(strconv.FormatInt(s.version+1, 10), resources)
+	if err != nil {
+		return err
+	}
+	if err := s.cache.SetSnapshot(context.Background(), XDS_SNAPSHOT_NODE, snapshot); err != nil {
+		return err
+	}
+	s.version++
+	s.hash = hash
+	logging.Log.Info("xds server snapshot version ", strconv.FormatInt(s.version, 10), " published")
+	return nil
+}
+
+// build xds resources of all proxies
+func xdsResources() map[resourcev3.Type][]types.Resource {
+	connLock.RLock()
+	defer connLock.RUnlock()
+
+	proxyNames := make([]string, 0, len(connProxy))
+	for proxyName := range connProxy {
+		proxyNames = append(proxyNames, proxyName)
+	}
+	sort.Strings(proxyNames)
+
+	resources := map[resourcev3.Type][]types.Resource{
+		resourcev3.ClusterType:  {},
+		resourcev3.EndpointType: {},
+		resourcev3.ListenerType: {},
+		resourcev3.RouteType:    {},
+	}
+	for _, proxyName := range proxyNames {
+		proxyModel, _ := connProxy[proxyName]["proxyModel"].(string)
+		listener, err := xdsListener(proxyName)
+		if err != nil {
+			logging.ERROR.Error("xds server build listener of ", proxyName, " error: ", err)
+			continue
+		}
+		resources[resourcev3.ClusterType] = append(resources[resourcev3.ClusterType], xdsCluster(proxyName, proxyModel))
+		resources[resourcev3.EndpointType] = append(resources[resourcev3.EndpointType], xdsLoadAssignment(proxyName, connPools[proxyName]))
+		resources[resourcev3.ListenerType] = append(resources[resourcev3.ListenerType], listener)
+		resources[resourcev3.RouteType] = append(resources[resourcev3.RouteType], xdsRouteConfig(proxyName))
+	}
+	return resources
+}
+
+// hash of resources, used to skip unchanged snapshots
+func xdsResourcesHash(resources map[resourcev3.Type][]types.Resource) (uint64, error) {
+	h := fnv.New64a()
+	for _, typeURL := range []string{resourcev3.ClusterType, resourcev3.EndpointType, resourcev3.ListenerType, resourcev3.RouteType} {
+		for _, res := range resources[typeURL] {
+			data, err := proto.MarshalOptions{Deterministic: true}.Marshal(res)
+			if err != nil {
+				return 0, err
+			}
+			h.Write(data)
+		}
+	}
+	return h.Sum64(), nil
+}
+
+// cluster of proxy, endpoints from eds
+func xdsCluster(proxyName, proxyModel string) *clusterv3.Cluster {
+	lbPolicy := clusterv3.Cluster_ROUND_ROBIN
+	if strings.ToLower(proxyModel) == "minconn" {
+		lbPolicy = clusterv3.Cluster_LEAST_REQUEST
+	}
+	return &clusterv3.Cluster{
+		Name:                 proxyName,
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		LbPolicy:             lbPolicy,
+		EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+			EdsConfig: &corev3.ConfigSource{
+				ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+			},
+		},
+	}
+}
+
+// load assignment of proxy pools, pools sorted by address
+func xdsLoadAssignment(proxyName string, pools map[string]*Pool) *endpointv3.ClusterLoadAssignment {
+	poolList := make([]*Pool, 0, len(pools))
+	for _, pool := range pools {
+		poolList = append(poolList, pool)
+	}
+	sort.Slice(poolList, func(i, j int) bool {
+		return poolList[i].poolRemoteAddr < poolList[j].poolRemoteAddr
+	})
+
+	var lbEndpoints []*endpointv3.LbEndpoint
+	for _, pool := range poolList {
+		host, portStr, err := net.SplitHostPort(pool.poolRemoteAddr)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			continue
+		}
+		weight := pool.weight
+		if weight <= 0 {
+			weight = 1
+		}
+		healthStatus := corev3.HealthStatus_HEALTHY
+		if !pool.status {
+			healthStatus = corev3.HealthStatus_UNHEALTHY
+		}
+		lbEndpoint := &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+				Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{
+						Address: &corev3.Address_SocketAddress{
+							SocketAddress: &corev3.SocketAddress{
+								Address:       host,
+								PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(port)},
+							},
+						},
+					},
+				},
+			},
+			HealthStatus:        healthStatus,
+			LoadBalancingWeight: wrapperspb.UInt32(uint32(weight)),
+		}
+		// labels as envoy.lb metadata, same as the xds client
+		if len(pool.labels) > 0 {
+			fields := make(map[string]interface{})
+			for k, v := range pool.labels {
+				fields[k] = v
+			}
+			if lbMetadata, err := structpb.NewStruct(fields); err == nil {
+				lbEndpoint.Metadata = &corev3.Metadata{
+					FilterMetadata: map[string]*structpb.Struct{XDS_LB_METADATA: lbMetadata},
+				}
+			}
+		}
+		lbEndpoints = append(lbEndpoints, lbEndpoint)
+	}
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: proxyName,
+		// proxyless grpc clients need locality and locality weight
+		Endpoints: []*endpointv3.LocalityLbEndpoints{{
+			Locality:            &corev3.Locality{SubZone: proxyName},
+			LbEndpoints:         lbEndpoints,
+			LoadBalancingWeight: wrapperspb.UInt32(1),
+		}},
+	}
+}
+
+// api listener of proxy, name is the target of xds:///<proxy>
+func xdsListener(proxyName string) (*listenerv3.Listener, error) {
+	router, err := anypb.New(&routerv3.Router{})
+	if err != nil {
+		return nil, err
+	}
+	manager, err := anypb.New(&hcmv3.HttpConnectionManager{
+		RouteSpecifier: &hcmv3.HttpConnectionManager_Rds{
+			Rds: &hcmv3.Rds{
+				RouteConfigName: proxyName,
+				ConfigSource: &corev3.ConfigSource{
+					ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+				},
+			},
+		},
+		HttpFilters: []*hcmv3.HttpFilter{{
+			Name:       wellknown.Router,
+			ConfigType: &hcmv3.HttpFilter_TypedConfig{TypedConfig: router},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &listenerv3.Listener{
+		Name:        proxyName,
+		ApiListener: &listenerv3.ApiListener{ApiListener: manager},
+	}, nil
+}
+
+// route config of proxy, all methods to the proxy cluster
+func xdsRouteConfig(proxyName string) *routev3.RouteConfiguration {
+	return &routev3.RouteConfiguration{
+		Name: proxyName,
+		VirtualHosts: []*routev3.VirtualHost{{
+			Name:    proxyName,
+			Domains: []string{"*"},
+			Routes: []*routev3.Route{{
+				Match: &routev3.RouteMatch{
+					PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: ""},
+				},
+				Action: &routev3.Route_Route{
+					Route: &routev3.RouteAction{
+						ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: proxyName},
+					},
+				},
+			}},
+		}},
+	}
+}
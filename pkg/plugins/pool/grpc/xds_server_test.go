@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestXdsServerSubsetRequest(t *testing.T) {
+	testProxy(t, "xsrv-a", nil)
+	testProxy(t, "xsrv-b", nil)
+	connLock.Lock()
+	connPools["xsrv-a"]["pool"] = &Pool{name: "pool", poolRemoteAddr: "10.0.0.1:50051", weight: 2, status: true}
+	connLock.Unlock()
+
+	xdsServer := NewXdsServer(nil)
+	if err := xdsServer.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	xdsServer.Register(srv)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := discoveryv3.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// proxyless clients subscribe only the clusters they use
+	err = stream.Send(&discoveryv3.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "client"},
+		TypeUrl:       resourcev3.EndpointType,
+		ResourceNames: []string{"xsrv-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Resources) != 1 {
+		t.Fatalf("resources %d, want 1", len(resp.Resources))
+	}
+	cla := &endpointv3.ClusterLoadAssignment{}
+	if err := resp.Resources[0].UnmarshalTo(cla); err != nil {
+		t.Fatal(err)
+	}
+	lbEndpoints := cla.GetEndpoints()[0].GetLbEndpoints()
+	if cla.ClusterName != "xsrv-a" || len(lbEndpoints) != 1 || lbEndpoints[0].GetLoadBalancingWeight().GetValue() != 2 {
+		t.Errorf("load assignment %v", cla)
+	}
+}
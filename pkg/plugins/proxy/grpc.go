@@ -9,7 +9,6 @@ import (
 
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
 )
 
 var grpcViper *viper.Viper
@@ -40,12 +39,36 @@ func (plugin *Plugin) GRPCServer() {
 			continue
 		}
 		// run grpc server
-		go plugin.vsGrpcInitServer(setting[strings.ToLower("LISTEN_PROXY_ADDR")].(string)+":"+vMap["PROXY_PORT"].(string), vMap["PROXY_NAME"].(string))
+		go plugin.vsGrpcInitServer(setting[strings.ToLower("LISTEN_PROXY_ADDR")].(string)+":"+vMap["PROXY_PORT"].(string), vMap["PROXY_NAME"].(string), vMap)
+	}
+	// run xds management server
+	if xdsConf, ok := rvRoot.(map[string]interface{})["xds_server"].(map[string]interface{}); ok && xdsConf["enabled"] == true {
+		go plugin.xdsInitServer(setting[strings.ToLower("LISTEN_PROXY_ADDR")].(string)+":"+fmt.Sprintf("%v", xdsConf["listen_port"]), xdsConf)
+	}
+}
+
+// xds management server
+func (plugin Plugin) xdsInitServer(addr string, conf map[string]interface{}) {
+	logging.Log.Info("xDS Server start ", addr, " ...")
+	lis, err := listen(addr)
+	if err != nil {
+		logging.ERROR.Errorf("failed to listen xds: %v", err)
+		return
+	}
+	xdsServer := grpcPool.NewXdsServer(conf)
+	srv := grpc.NewServer()
+	registerXdsServer(srv)
+	xdsServer.Register(srv)
+	xdsServer.Start()
+	err = srv.Serve(lis)
+	if err != nil {
+		logging.ERROR.Errorf("failed to serve xds: %v", err)
+		return
 	}
 }
 
 //grpc server
-func (plugin Plugin) vsGrpcInitServer(addr, serviceName string) {
+func (plugin Plugin) vsGrpcInitServer(addr, serviceName string, vMap map[string]interface{}) {
 	logging.Log.Info(serviceName, " gRPC Server start ...")
 	//goroutine break
 	defer func() {
@@ -53,22 +76,25 @@ func (plugin Plugin) vsGrpcInitServer(addr, serviceName string) {
 	}()
 
 	// get gRPC port
-	lis, err := net.Listen("tcp", addr)
+	lis, err := listen(addr)
 	if err != nil {
 		logging.ERROR.Errorf("failed to listen: %v", err)
 		return
 	}
 	// grpc new server
 	srv := grpc.NewServer(grpc.CustomCodec(grpcPool.Codec()),
+		grpc.MaxRecvMsgSize(grpcPool.ServerMaxRecvSize(vMap)),
 		grpc.UnknownServiceHandler(grpcPool.TransparentHandler(grpcPool.GrpcProxyTransport)))
-	// register service
-	grpcPool.RegisterService(srv, grpcPool.GrpcProxyTransport,
-		"PingEmpty",
-		"Ping",
-		"PingError",
-		"PingList",
-	)
-	reflection.Register(srv)
+	registerGrpcServer(serviceName, srv)
+	// all methods are handled by the transparent handler, known services and policies from proxy SERVICES config
+	// reflection of all backend services
+	grpcPool.RegisterReflection(srv)
+	// grpc web on the same port, HTTP/1.1 connections are served by grpc web handler
+	if webEnabled, allowOrigins := grpcWebConfig(vMap); webEnabled {
+		var webLis net.Listener
+		lis, webLis = splitHTTPListener(lis)
+		go serveGrpcWeb(webLis, srv, serviceName, allowOrigins)
+	}
 	// start ser listen
 	err = srv.Serve(lis)
 	if err != nil {
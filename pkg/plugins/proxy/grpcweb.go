@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	"synapsor/pkg/plugins/httpserver/middleware"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+)
+
+// grpc web content type
+const (
+	GRPC_WEB_CONTENT_TYPE      = "application/grpc-web"
+	GRPC_WEB_TEXT_CONTENT_TYPE = "application/grpc-web-text"
+)
+
+// grpc web trailer frame flag
+const grpcWebTrailerFlag byte = 0x80
+
+// grpc web config of proxy
+func grpcWebConfig(vMap map[string]interface{}) (bool, []string) {
+	webConf, ok := vMap["GRPC_WEB"].(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	enabled, _ := webConf["ENABLED"].(bool)
+	var origins []string
+	if list, ok := webConf["ALLOW_ORIGINS"].([]interface{}); ok {
+		for _, origin := range list {
+			origins = append(origins, origin.(string))
+		}
+	}
+	return enabled, origins
+}
+
+// serve grpc web (HTTP/1.1) requests, translate to the grpc server
+func serveGrpcWeb(lis net.Listener, srv *grpc.Server, serviceName string, allowOrigins []string) {
+	r := gin.New()
+	r.Use(gin.Recovery(), middleware.CorsGrpcWeb(allowOrigins))
+	r.POST("/:service/:method", grpcWebHandler(srv))
+	logging.Log.Info(serviceName, " gRPC-Web Server start ...")
+	webSrv := &http.Server{Handler: r}
+	registerWebServer(webSrv)
+	if err := webSrv.Serve(lis); err != nil && err != http.ErrServerClosed {
+		logging.ERROR.Errorf("failed to serve grpc web: %v", err)
+	}
+}
+
+// grpc web handler
+func grpcWebHandler(srv *grpc.Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		contentType := c.Request.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, GRPC_WEB_CONTENT_TYPE) {
+			c.AbortWithStatus(http.StatusUnsupportedMediaType)
+			return
+		}
+		text := strings.HasPrefix(contentType, GRPC_WEB_TEXT_CONTENT_TYPE)
+
+		// rewrite request as native grpc over HTTP/2
+		req := c.Request.Clone(c.Request.Context())
+		req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2"
+		req.Header.Set("Content-Type", "application/grpc+proto")
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+		req.Body = c.Request.Body
+		if text {
+			req.Body = io.NopCloser(&grpcWebTextReader{r: c.Request.Body})
+		}
+
+		w := &grpcWebResponseWriter{
+			writer:      c.Writer,
+			header:      make(http.Header),
+			contentType: contentType,
+			text:        text,
+		}
+		srv.ServeHTTP(w, req)
+		w.finish()
+	}
+}
+
+// grpcWebTextReader 解码 grpc-web-text 请求 body, 客户端每个消息单独 base64 编码和填充
+type grpcWebTextReader struct {
+	r   io.Reader
+	buf []byte
+	in  []byte // not decoded, less than a quantum
+	out []byte // decoded, not read
+	err error
+}
+
+// read decoded bytes, base64 is decoded by quanta, a padded quantum ends a chunk
+func (r *grpcWebTextReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			if r.err == io.EOF && len(r.in) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, r.err
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, 4096)
+		}
+		n, err := r.r.Read(r.buf)
+		r.err = err
+		r.in = append(r.in, r.buf[:n]...)
+		end := len(r.in) / 4 * 4
+		for start := 0; start < end; {
+			stop := end
+			if i := bytes.IndexByte(r.in[start:end], '='); i >= 0 {
+				stop = start + (i/4+1)*4
+			}
+			decoded := make([]byte, base64.StdEncoding.DecodedLen(stop-start))
+			m, err := base64.StdEncoding.Decode(decoded, r.in[start:stop])
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.out = append(r.out, decoded[:m]...)
+			start = stop
+		}
+		r.in = append(r.in[:0], r.in[end:]...)
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// grpcWebResponseWriter 将 grpc 响应转换为 grpc web 响应, trailers 写入 body 的 trailer frame
+type grpcWebResponseWriter struct {
+	writer        http.ResponseWriter
+	header        http.Header
+	contentType   string
+	text          bool
+	textBuf       bytes.Buffer
+	headerWritten bool
+}
+
+// response header
+func (w *grpcWebResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// write header
+func (w *grpcWebResponseWriter) WriteHeader(code int) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+	h := w.writer.Header()
+	for k, v := range w.header {
+		if k == "Trailer" || strings.HasPrefix(k, http2.TrailerPrefix) {
+			continue
+		}
+		h[k] = v
+	}
+	h.Set("Content-Type", w.contentType)
+	h.Del("Content-Length")
+	w.writer.WriteHeader(code)
+}
+
+// write body, buffered until flush in text mode
+func (w *grpcWebResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.text {
+		return w.textBuf.Write(b)
+	}
+	return w.writer.Write(b)
+}
+
+// flush, base64 encode buffered frames in text mode
+func (w *grpcWebResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+	if w.text && w.textBuf.Len() > 0 {
+		w.writer.Write([]byte(base64.StdEncoding.EncodeToString(w.textBuf.Bytes())))
+		w.textBuf.Reset()
+	}
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// write trailers as the last frame
+func (w *grpcWebResponseWriter) finish() {
+	declared := make(map[string]bool)
+	for _, k := range w.header.Values("Trailer") {
+		declared[http.CanonicalHeaderKey(k)] = true
+	}
+	var trailer bytes.Buffer
+	for k, vv := range w.header {
+		name := strings.TrimPrefix(k, http2.TrailerPrefix)
+		if name == k && !declared[k] {
+			continue
+		}
+		for _, v := range vv {
+			trailer.WriteString(strings.ToLower(name) + ": " + v + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+trailer.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailer.Len()))
+	frame = append(frame, trailer.Bytes()...)
+	w.Write(frame)
+	w.Flush()
+}
@@ -8,6 +8,7 @@ import (
 	"synapsor/pkg/plugins/httpserver/controller"
 	"synapsor/pkg/plugins/httpserver/middleware"
 	"synapsor/pkg/plugins/httpserver/util"
+	grpcPool "synapsor/pkg/plugins/pool/grpc"
 
 	// "strconv"
 
@@ -67,7 +68,16 @@ func (plugin *Plugin) HttpServer() {
 	}
 	// log server addr
 	logging.Log.Info("http server runing :" + serverPort)
-	r.Run(":" + serverPort)
+	lis, err := listen(":" + serverPort)
+	if err != nil {
+		logging.ERROR.Errorf("failed to listen http: %v", err)
+		return
+	}
+	srv := &http.Server{Handler: r}
+	registerHttpServer(srv)
+	if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+		logging.ERROR.Errorf("failed to serve http: %v", err)
+	}
 }
 
 // http proxy
@@ -252,11 +262,33 @@ func definitionRoute(router *gin.Engine) {
 		timeOutNumInt64, _ := strconv.ParseInt(timeOutDuration, 10, 64)
 		timeOutNum = (time.Duration)(timeOutNumInt64)
 	}
+	// http/json to grpc transcoding, before time out handler so streaming response is not buffered,
+	// unary calls use the same time out as backend deadline
+	grpcPool.TranscodeTimeout = time.Second * timeOutNum
+	var transcodeController *controller.TranscodeController
+	router.Use(transcodeController.Transcode)
 	router.Use(middleware.TimeoutHandler(time.Second * timeOutNum))
 	// metrics data api
 	var metricsController *controller.MetricsController
 	// metrics api
 	router.GET("/proxy/metricsdata", metricsController.GetPoolMetricsData)
+	// prometheus metrics
+	router.GET("/metrics", metricsController.GetPrometheusMetrics)
+	// mirror metrics api
+	router.GET("/proxy/mirrordata", metricsController.GetMirrorMetricsData)
+	// fault injection api
+	var faultController *controller.FaultController
+	router.GET("/proxy/faults", faultController.GetFaultRules)
+	router.PUT("/proxy/faults/:proxy/:rule", faultController.SwitchFaultRule)
+	// transcoding routes api
+	router.GET("/proxy/transcoding", transcodeController.GetTranscodeRoutes)
+	// descriptors registry api
+	var descriptorController *controller.DescriptorController
+	router.GET("/proxy/descriptors", descriptorController.GetDescriptors)
+	router.POST("/proxy/descriptors/:proxy/refresh", descriptorController.RefreshDescriptors)
+	// readiness probe
+	var healthController *controller.HealthController
+	router.GET("/ready", healthController.GetReady)
 	// no route
 	router.NoRoute(noRouteResponse)
 }
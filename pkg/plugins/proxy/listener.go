@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// protocol detect timeout of new connection
+var DetectTimeout = 10 * time.Second
+
+// splitListener 按连接的前几个字节区分 HTTP/2 (原生 gRPC) 和 HTTP/1.1 (gRPC-Web)
+type splitListener struct {
+	net.Listener
+	http2Conns chan net.Conn
+	http1Conns chan net.Conn
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// child listener of split listener
+type childListener struct {
+	parent *splitListener
+	conns  chan net.Conn
+}
+
+// conn with peeked bytes
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// split listener into HTTP/2 and HTTP/1.1 listeners
+func splitHTTPListener(lis net.Listener) (net.Listener, net.Listener) {
+	s := &splitListener{
+		Listener:   lis,
+		http2Conns: make(chan net.Conn),
+		http1Conns: make(chan net.Conn),
+		done:       make(chan struct{}),
+	}
+	go s.serve()
+	return &childListener{parent: s, conns: s.http2Conns}, &childListener{parent: s, conns: s.http1Conns}
+}
+
+// accept and dispatch connections
+func (s *splitListener) serve() {
+	defer s.close()
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return
+		}
+		go s.dispatch(conn)
+	}
+}
+
+// detect protocol by client preface
+func (s *splitListener) dispatch(conn net.Conn) {
+	reader := bufio.NewReaderSize(conn, len(http2.ClientPreface))
+	conn.SetReadDeadline(time.Now().Add(DetectTimeout))
+	conns := s.http2Conns
+	// stop peeking as soon as the bytes differ from the preface
+	for n := 1; n <= len(http2.ClientPreface); n++ {
+		peeked, err := reader.Peek(n)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		if !bytes.Equal(peeked, []byte(http2.ClientPreface[:n])) {
+			conns = s.http1Conns
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	select {
+	case conns <- &peekedConn{Conn: conn, reader: reader}:
+	case <-s.done:
+		conn.Close()
+	}
+}
+
+// close split listener
+func (s *splitListener) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.Listener.Close()
+	})
+}
+
+// accept connection
+func (l *childListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.parent.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// close closes the parent listener
+func (l *childListener) Close() error {
+	l.parent.close()
+	return nil
+}
+
+// listener addr
+func (l *childListener) Addr() net.Addr {
+	return l.parent.Addr()
+}
+
+// read peeked bytes first
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
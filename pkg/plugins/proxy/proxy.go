@@ -3,6 +3,7 @@ package proxy
 import (
 	"net/http"
 	"synapsor/pkg/plugins"
+	"synapsor/pkg/plugins/httpserver/db"
 	grpcPool "synapsor/pkg/plugins/pool/grpc"
 )
 
@@ -22,6 +23,10 @@ func (plugin *Plugin) VsServer() {
 	defer func() {
 		plugin.Status <- false
 	}()
+	// redis client of response cache
+	grpcPool.SetCacheClient(db.Cache)
 	// init vs grpc pool
 	grpcPool.InitGrpcConnPool()
+	// ready after pools initialized
+	grpcPool.SetReady(true)
 }
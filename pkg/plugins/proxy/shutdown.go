@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	logging "synapsor/pkg/core/log"
+	grpcPool "synapsor/pkg/plugins/pool/grpc"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+)
+
+// running servers, stopped in order at shutdown
+var (
+	serversLock sync.Mutex
+	grpcServers = make(map[string]*grpc.Server)
+	webServers  []*http.Server
+	httpServer  *http.Server
+	xdsServer   *grpc.Server
+)
+
+// register grpc server of proxy
+func registerGrpcServer(name string, srv *grpc.Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	grpcServers[name] = srv
+}
+
+// register xds management server
+func registerXdsServer(srv *grpc.Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	xdsServer = srv
+}
+
+// register grpc web server
+func registerWebServer(srv *http.Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	webServers = append(webServers, srv)
+}
+
+// register admin http server
+func registerHttpServer(srv *http.Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	httpServer = srv
+}
+
+// get shutdown seconds config, env overrides config file
+func shutdownConfig(key string, defaultValue int) time.Duration {
+	seconds := defaultValue
+	if value := os.Getenv(key); value != "" {
+		seconds, _ = strconv.Atoi(value)
+	} else if viper.IsSet(key) {
+		seconds = viper.GetInt(key)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// graceful shutdown: mark not ready, drain proxy servers within SHUTDOWN_TIMEOUT,
+// then stop admin http server, close pools, tracing and log files
+func Shutdown() {
+	timeout := shutdownConfig("SHUTDOWN_TIMEOUT", 30)
+	delay := shutdownConfig("SHUTDOWN_DELAY", 0)
+	if atomic.LoadInt32(&upgraded) == 1 {
+		// listeners are served by the new process, keep ready and drain at once
+		logging.Log.Info("shutdown after upgrade, stop servers in ", upgradeGrace)
+		stopListeners()
+	} else {
+		// readiness fails first, load balancers stop sending new connections during the delay
+		grpcPool.SetReady(false)
+		logging.Log.Info("shutdown, not ready, stop servers in ", delay)
+		time.Sleep(delay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	serversLock.Lock()
+	servers := make(map[string]*grpc.Server, len(grpcServers))
+	for name, srv := range grpcServers {
+		servers[name] = srv
+	}
+	webs := append([]*http.Server(nil), webServers...)
+	admin := httpServer
+	xds := xdsServer
+	serversLock.Unlock()
+
+	// ads streams never end, clients reconnect to other instances
+	if xds != nil {
+		xds.Stop()
+		logging.Log.Info("xDS Server stopped")
+	}
+
+	// stop accepting and wait for in-flight streams, streams are cut at the deadline
+	var wg sync.WaitGroup
+	for name, srv := range servers {
+		wg.Add(1)
+		go func(name string, srv *grpc.Server) {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+				logging.Log.Info(name, " gRPC Server stopped")
+			case <-ctx.Done():
+				srv.Stop()
+				logging.ERROR.Error(name, " gRPC Server stop timeout, in-flight streams are closed")
+			}
+		}(name, srv)
+	}
+	for _, srv := range webs {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	// admin api is served until proxy servers stopped
+	if admin != nil {
+		if err := admin.Shutdown(ctx); err != nil {
+			logging.ERROR.Error("shutdown http server: ", err)
+			admin.Close()
+		}
+	}
+	grpcPool.ClosePools()
+	if err := grpcPool.ShutdownTracing(ctx); err != nil {
+		logging.ERROR.Error("shutdown tracing: ", err)
+	}
+	logging.Log.Info("shutdown finish")
+	logging.Close()
+}
@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	logging "synapsor/pkg/core/log"
+	grpcPool "synapsor/pkg/plugins/pool/grpc"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// first fd of passed listeners, same as systemd SD_LISTEN_FDS_START
+const listenFdsStart = 3
+
+// env of the pipe fd, the new process writes to it when ready
+const upgradeReadyFdEnv = "SYNAPSOR_UPGRADE_READY_FD"
+
+// connections accepted before the listeners are stopped send requests during the grace
+const upgradeGrace = time.Second
+
+// servers take inherited listeners in this time after pools initialized, unused ones are closed
+const inheritedWait = time.Second
+
+// listeners inherited from parent process or systemd, not used yet,
+// and listeners in use by addr, passed to the new process on upgrade
+var (
+	listenersLock sync.Mutex
+	inheritOnce   sync.Once
+	inherited     []*net.TCPListener
+	listeners     = make(map[string]*upgradeListener)
+)
+
+// 1 after the new process is ready, this process only drains
+var upgraded int32
+
+// upgradeListener 升级后停止 accept, 新连接由新进程处理, Accept 阻塞到服务端关闭 listener
+type upgradeListener struct {
+	*net.TCPListener
+	stopped   chan struct{}
+	closed    chan struct{}
+	stopOnce  sync.Once
+	closeOnce sync.Once
+}
+
+// listeners passed by LISTEN_FDS, LISTEN_PID is checked when set by systemd
+func inheritListeners() {
+	defer func() {
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), "listener")
+		lis, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			logging.ERROR.Error("inherit listener fd ", fd, ": ", err)
+			continue
+		}
+		tcpLis, ok := lis.(*net.TCPListener)
+		if !ok {
+			lis.Close()
+			logging.ERROR.Error("inherit listener fd ", fd, ": not a tcp listener")
+			continue
+		}
+		inherited = append(inherited, tcpLis)
+		logging.Log.Info("inherit listener ", tcpLis.Addr())
+	}
+}
+
+// whether the listener is bound to addr, unspecified host matches any unspecified ip
+func matchListenAddr(addr string, lisAddr net.Addr) bool {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	lisTCPAddr, ok := lisAddr.(*net.TCPAddr)
+	if err != nil || !ok || tcpAddr.Port != lisTCPAddr.Port {
+		return false
+	}
+	if tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+		return lisTCPAddr.IP.IsUnspecified()
+	}
+	return tcpAddr.IP.Equal(lisTCPAddr.IP)
+}
+
+// listen on addr, the inherited listener of the same addr is used if exists
+func listen(addr string) (net.Listener, error) {
+	inheritOnce.Do(inheritListeners)
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	var lis *net.TCPListener
+	for i, l := range inherited {
+		if matchListenAddr(addr, l.Addr()) {
+			lis = l
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			break
+		}
+	}
+	if lis == nil {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		lis = l.(*net.TCPListener)
+	}
+	upgradeLis := &upgradeListener{
+		TCPListener: lis,
+		stopped:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	listeners[addr] = upgradeLis
+	return upgradeLis, nil
+}
+
+// accept connection, block until closed after stopped
+func (l *upgradeListener) Accept() (net.Conn, error) {
+	conn, err := l.TCPListener.Accept()
+	if err != nil {
+		select {
+		case <-l.stopped:
+			<-l.closed
+			return nil, net.ErrClosed
+		default:
+		}
+	}
+	return conn, err
+}
+
+// stop accepting, the socket is still open in the new process
+func (l *upgradeListener) stop() {
+	l.stopOnce.Do(func() {
+		close(l.stopped)
+		l.TCPListener.Close()
+	})
+}
+
+// close by server
+func (l *upgradeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	l.stop()
+	return nil
+}
+
+// stop accepting of all listeners after upgrade, wait for requests of accepted connections
+func stopListeners() {
+	listenersLock.Lock()
+	for _, lis := range listeners {
+		lis.stop()
+	}
+	listenersLock.Unlock()
+	time.Sleep(upgradeGrace)
+}
+
+// env of the new process, listener env of this process are removed
+func upgradeEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		switch strings.SplitN(kv, "=", 2)[0] {
+		case "LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES", upgradeReadyFdEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// start the new binary with listeners in use, return after the new process is ready,
+// the new process is stopped if not ready in UPGRADE_TIMEOUT
+func Upgrade() error {
+	// listeners are open until shutdown, fds are valid while starting the new process
+	listenersLock.Lock()
+	var fds []uintptr
+	var names []string
+	for addr, lis := range listeners {
+		rawConn, err := lis.SyscallConn()
+		if err != nil {
+			listenersLock.Unlock()
+			return fmt.Errorf("listener %s: %w", addr, err)
+		}
+		rawConn.Control(func(fd uintptr) {
+			fds = append(fds, fd)
+		})
+		names = append(names, addr)
+	}
+	listenersLock.Unlock()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	env := append(upgradeEnv(),
+		"LISTEN_FDS="+strconv.Itoa(len(fds)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		upgradeReadyFdEnv+"="+strconv.Itoa(listenFdsStart+len(fds)))
+	process, err := startProcess(env, append(fds, readyWriter.Fd()))
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	logging.Log.Info("upgrade, new process ", process.Pid, " started with listeners ", names)
+	// reap the new process if it exits before this process
+	go process.Wait()
+
+	// EOF if the new process exits before ready
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err
+	}()
+	timeout := shutdownConfig("UPGRADE_TIMEOUT", 60)
+	select {
+	case err := <-ready:
+		if err != nil {
+			return fmt.Errorf("new process %d exited before ready", process.Pid)
+		}
+		logging.Log.Info("upgrade, new process ", process.Pid, " ready")
+		atomic.StoreInt32(&upgraded, 1)
+		return nil
+	case <-time.After(timeout):
+		process.Signal(syscall.SIGTERM)
+		return fmt.Errorf("new process %d not ready in %s", process.Pid, timeout)
+	}
+}
+
+// whether all inherited listeners are served
+func inheritedServed() bool {
+	inheritOnce.Do(inheritListeners)
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	return len(inherited) == 0
+}
+
+// close inherited listeners not used by any server, e.g. port removed from config
+func closeInheritedListeners() {
+	listenersLock.Lock()
+	defer listenersLock.Unlock()
+	for _, lis := range inherited {
+		logging.Log.Info("inherited listener ", lis.Addr(), " not used, closed")
+		lis.Close()
+	}
+	inherited = nil
+}
+
+// notify the parent process when ready, only for process started by upgrade
+func NotifyUpgradeReady() {
+	fd, err := strconv.Atoi(os.Getenv(upgradeReadyFdEnv))
+	os.Unsetenv(upgradeReadyFdEnv)
+	if err != nil {
+		return
+	}
+	file := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer file.Close()
+	for !grpcPool.Ready() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	// ready when servers started, inherited listeners unused by then do not block readiness
+	deadline := time.Now().Add(inheritedWait)
+	for !inheritedServed() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	closeInheritedListeners()
+	if _, err := file.Write([]byte{1}); err != nil {
+		logging.ERROR.Error("notify upgrade ready: ", err)
+		return
+	}
+	logging.Log.Info("upgrade ready, notify parent process ", os.Getppid())
+}
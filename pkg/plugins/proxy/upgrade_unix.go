@@ -0,0 +1,26 @@
+//go:build !windows
+
+package proxy
+
+import (
+	"os"
+	"syscall"
+)
+
+// signal starting upgrade
+var UpgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// start the executable with fds from 3, listener fds are passed as is,
+// os.File sets the shared socket to blocking mode
+func startProcess(env []string, fds []uintptr) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	files := append([]uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}, fds...)
+	pid, err := syscall.ForkExec(executable, os.Args, &syscall.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return nil, err
+	}
+	return os.FindProcess(pid)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package proxy
+
+import (
+	"errors"
+	"os"
+)
+
+// upgrade is not supported on windows
+var UpgradeSignals []os.Signal
+
+// upgrade is not supported on windows
+func startProcess(env []string, fds []uintptr) (*os.Process, error) {
+	return nil, errors.New("upgrade is not supported on windows")
+}
@@ -2,11 +2,14 @@
 package main
 
 import (
+	"os"
+	"os/signal"
 	logging "synapsor/pkg/core/log"
 	"synapsor/pkg/plugins"
 	"synapsor/pkg/plugins/httpserver/util"
 	"synapsor/pkg/plugins/metrics"
 	"synapsor/pkg/plugins/proxy"
+	"syscall"
 )
 
 // main
@@ -23,11 +26,38 @@ func main() {
 	//register Metrics Data Server
 	metricsPluginChan := registerPlugins(metricsPlugin.ShowMetrics)
 	vsPluginChan := registerPlugins(vsPlugin.VsServer)
-	// return status chan
-	<-httpPluginChan
-	<-gRPCPluginChan
-	<-vsPluginChan
-	<-metricsPluginChan
+	// notify parent process when started by upgrade
+	go proxy.NotifyUpgradeReady()
+	// graceful shutdown on SIGTERM / SIGINT, upgrade on SIGUSR2
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, append([]os.Signal{syscall.SIGTERM, syscall.SIGINT}, proxy.UpgradeSignals...)...)
+	done := make(chan struct{})
+	go func() {
+		// return status chan
+		<-httpPluginChan
+		<-gRPCPluginChan
+		<-vsPluginChan
+		<-metricsPluginChan
+		close(done)
+	}()
+	for {
+		select {
+		case sig := <-quit:
+			if sig != syscall.SIGTERM && sig != syscall.SIGINT {
+				// start new process with listeners, keep serving if upgrade failed
+				logging.Log.Info("receive signal ", sig, ", upgrade ...")
+				if err := proxy.Upgrade(); err != nil {
+					logging.ERROR.Error("upgrade failed: ", err)
+					continue
+				}
+			}
+			logging.Log.Info("receive signal ", sig, ", shutdown ...")
+			proxy.Shutdown()
+			return
+		case <-done:
+			return
+		}
+	}
 }
 
 // register plugins
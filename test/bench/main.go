@@ -0,0 +1,191 @@
+// pool benchmark: unary calls through endpoint pools of each POOL_MODEL against a local backend
+//
+//	go run ./test/bench -concurrency 64 -duration 5s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpcPool "synapsor/pkg/plugins/pool/grpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// benchmark flags
+var (
+	concurrency   = flag.Int("concurrency", 64, "concurrent callers")
+	duration      = flag.Duration("duration", 5*time.Second, "duration of each mode")
+	conns         = flag.Int("conns", 4, "connections of the pool, DEFAULT_GRPC_CONN_NUM")
+	streams       = flag.Int("streams", 100, "max concurrent streams of a connection in multiplex mode, MAX_CONCURRENT_STREAMS")
+	serverStreams = flag.Uint("server-streams", 0, "MAX_CONCURRENT_STREAMS advertised by the backend, 0 not advertised")
+	latency       = flag.Duration("latency", time.Millisecond, "backend processing time of a call")
+	size          = flag.Int("size", 256, "payload bytes of request and response")
+	modes         = flag.String("modes", "0,1,2", "POOL_MODEL to run, 0: STRICT_MODE, 1: LOOSE_MODE, 2: MULTIPLEX_MODE")
+)
+
+// name of pool model
+var modeNames = map[int]string{
+	grpcPool.STRICT_MODE:    "strict",
+	grpcPool.LOOSE_MODE:     "loose",
+	grpcPool.MULTIPLEX_MODE: "multiplex",
+}
+
+// result of a mode
+type result struct {
+	mode      int
+	calls     int64
+	errors    int64
+	elapsed   time.Duration
+	latencies []time.Duration
+	conns     int32 // max connections in use
+}
+
+func main() {
+	flag.Parse()
+	addr, stop, err := startBackend()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "start backend:", err)
+		os.Exit(1)
+	}
+	defer stop()
+
+	fmt.Printf("backend %s, concurrency %d, conns %d, streams %d, server streams %d, latency %s, size %d\n",
+		addr, *concurrency, *conns, *streams, *serverStreams, *latency, *size)
+	fmt.Printf("%-10s %8s %12s %10s %10s %10s %8s\n", "mode", "conns", "calls/s", "p50", "p99", "max", "errors")
+	for _, m := range strings.Split(*modes, ",") {
+		mode, err := strconv.Atoi(strings.TrimSpace(m))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid mode:", m)
+			os.Exit(1)
+		}
+		r, err := run(addr, mode)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "run mode", mode, ":", err)
+			os.Exit(1)
+		}
+		r.print()
+	}
+}
+
+// backend echoing the request after latency
+func startBackend() (string, func(), error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	var opts []grpc.ServerOption
+	if *serverStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(uint32(*serverStreams)))
+	}
+	opts = append(opts, grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		req := &wrapperspb.BytesValue{}
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		time.Sleep(*latency)
+		return stream.SendMsg(req)
+	}))
+	srv := grpc.NewServer(opts...)
+	go srv.Serve(lis)
+	return lis.Addr().String(), srv.Stop, nil
+}
+
+// call the backend from concurrent callers for duration
+func run(addr string, mode int) (*result, error) {
+	pool, err := grpcPool.NewGrpcPool(addr, grpcPool.Options{
+		PoolModel:            mode,
+		MaxIdle:              *conns,
+		MaxActive:            *conns,
+		MaxConcurrentStreams: *streams,
+		RequestIdleTime:      10,
+		RequestMaxLife:       60,
+		RequestTimeOut:       3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer pool.CloseWait()
+
+	req := &wrapperspb.BytesValue{Value: make([]byte, *size)}
+	// warm up connections
+	call(pool, req)
+
+	r := &result{mode: mode}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(*duration)
+	start := time.Now()
+	// sample connections in use
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for time.Now().Before(deadline) {
+			if n := pool.GetConnCurrent(); n > r.conns {
+				r.conns = n
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var latencies []time.Duration
+			for time.Now().Before(deadline) {
+				callStart := time.Now()
+				if err := call(pool, req); err != nil {
+					atomic.AddInt64(&r.errors, 1)
+					continue
+				}
+				latencies = append(latencies, time.Since(callStart))
+			}
+			lock.Lock()
+			r.latencies = append(r.latencies, latencies...)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	r.elapsed = time.Since(start)
+	r.calls = int64(len(r.latencies))
+	<-sampled
+	return r, nil
+}
+
+// unary call with a client of the pool
+func call(pool *grpcPool.Pool, req *wrapperspb.BytesValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	resp := &wrapperspb.BytesValue{}
+	return client.Invoke(ctx, "/bench.Echo/Call", req, resp)
+}
+
+// print throughput and latency percentiles
+func (r *result) print() {
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(r.latencies) == 0 {
+			return 0
+		}
+		return r.latencies[int(float64(len(r.latencies)-1)*p)]
+	}
+	fmt.Printf("%-10s %8d %12.0f %10s %10s %10s %8d\n", modeNames[r.mode], r.conns,
+		float64(r.calls)/r.elapsed.Seconds(),
+		percentile(0.5).Round(time.Microsecond), percentile(0.99).Round(time.Microsecond),
+		percentile(1).Round(time.Microsecond), r.errors)
+}